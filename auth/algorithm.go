@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Algorithms of tokens, see RFC 7518 and RFC 8037.
+const (
+	AlgorithmES256 = "ES256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmPS256 = "PS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// ErrAlgorithm is returned for tokens whose alg header is not the algorithm of the key, so that a token
+// cannot make the key verify it with another algorithm, and for algorithms not suiting the type of a key.
+var ErrAlgorithm = errors.New("unexpected algorithm")
+
+// minRSABits is the shortest RSA modulus accepted, see RFC 7518 section 3.3.
+const minRSABits = 2048
+
+// methodOf returns the signing method of the public key. An empty alg selects the default algorithm
+// of the key type: ES256 for P-256 keys, RS256 for RSA keys and EdDSA for Ed25519 keys.
+func methodOf(key crypto.PublicKey, alg string) (jwt.SigningMethod, error) {
+	var algs []string
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		algs = []string{AlgorithmES256}
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key is shorter than %d bits", minRSABits)
+		}
+		algs = []string{AlgorithmRS256, AlgorithmPS256}
+	case ed25519.PublicKey:
+		algs = []string{AlgorithmEdDSA}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if alg == "" {
+		alg = algs[0]
+	}
+	for _, a := range algs {
+		if a == alg {
+			return jwt.GetSigningMethod(alg), nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s for %T", ErrAlgorithm, alg, key)
+}
+
+// parsePublicKey parses a PEM encoded PKIX or PKCS #1 public key or the public key of a certificate.
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// parsePrivateKey parses a PEM encoded SEC 1, PKCS #1 or PKCS #8 private key.
+func parsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// signingMethodEdDSA signs tokens with Ed25519 keys, see RFC 8037. jwt-go does not provide it.
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgorithmEdDSA, func() jwt.SigningMethod {
+		return signingMethodEdDSA{}
+	})
+}
+
+func (signingMethodEdDSA) Alg() string {
+	return AlgorithmEdDSA
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok || len(k) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(k, []byte(signingString))), nil
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	k, ok := key.(ed25519.PublicKey)
+	if !ok || len(k) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(k, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeKeys returns the PKCS #8 private key and the PKIX public key in PEM.
+func encodeKeys(t *testing.T, key crypto.Signer) (string, string) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	private := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	der, err = x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	return private, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecPrivate, ecPublic := encodeKeys(t, ecKey)
+	rsaPrivate, rsaPublic := encodeKeys(t, rsaKey)
+	edPrivate, edPublic := encodeKeys(t, edKey)
+
+	for _, tc := range []struct {
+		alg, private, public string
+	}{
+		{AlgorithmES256, ecPrivate, ecPublic},
+		{AlgorithmRS256, rsaPrivate, rsaPublic},
+		{AlgorithmPS256, rsaPrivate, rsaPublic},
+		{AlgorithmEdDSA, edPrivate, edPublic},
+	} {
+		tc := tc
+		t.Run(tc.alg, func(t *testing.T) {
+			t.Parallel()
+
+			sk, err := NewSecretKeyAlg("", tc.alg, tc.private)
+			require.NoError(t, err)
+			token, err := sk.Sign(NewAPIClaims("test", uuid.New()))
+			require.NoError(t, err)
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &APIClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.alg, parsed.Header["alg"])
+
+			pk, err := NewPublicKeyAlg(tc.alg, tc.public)
+			require.NoError(t, err)
+			require.NoError(t, pk.Verify(token, &APIClaims{}))
+		})
+	}
+
+	t.Run("Default algorithm of the key type", func(t *testing.T) {
+		t.Parallel()
+
+		for private, alg := range map[string]string{
+			ecPrivate:  AlgorithmES256,
+			rsaPrivate: AlgorithmRS256,
+			edPrivate:  AlgorithmEdDSA,
+		} {
+			sk, err := NewSecretKey("", private)
+			require.NoError(t, err)
+			assert.Equal(t, alg, sk.method.Alg())
+		}
+	})
+
+	t.Run("Algorithm not suiting the key is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewPublicKeyAlg(AlgorithmEdDSA, rsaPublic)
+		require.ErrorIs(t, err, ErrAlgorithm)
+		_, err = NewSecretKeyAlg("", AlgorithmRS256, ecPrivate)
+		require.ErrorIs(t, err, ErrAlgorithm)
+		_, err = NewPublicKeyAlg("HS256", ecPublic)
+		require.ErrorIs(t, err, ErrAlgorithm)
+
+		short, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		_, shortPublic := encodeKeys(t, short)
+		_, err = NewPublicKey(shortPublic)
+		require.Error(t, err)
+	})
+
+	t.Run("Token of another algorithm is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		pk, err := NewPublicKey(rsaPublic)
+		require.NoError(t, err)
+
+		// The same RSA key signing with PSS instead of PKCS #1 v1.5.
+		pss, err := NewSecretKeyAlg("", AlgorithmPS256, rsaPrivate)
+		require.NoError(t, err)
+		token, err := pss.Sign(NewAPIClaims("test", uuid.New()))
+		require.NoError(t, err)
+		require.ErrorIs(t, pk.Verify(token, &APIClaims{}), ErrAlgorithm)
+
+		// HMAC keyed by the public key, which is known to everyone.
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, NewAPIClaims("test", uuid.New())).SignedString([]byte(rsaPublic))
+		require.NoError(t, err)
+		require.ErrorIs(t, pk.Verify(token, &APIClaims{}), ErrAlgorithm)
+
+		token, err = jwt.NewWithClaims(jwt.SigningMethodNone, NewAPIClaims("test", uuid.New())).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		require.Error(t, pk.Verify(token, &APIClaims{}))
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "rsa.PS256.pem"), []byte(rsaPublic), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2022.11.pem"), []byte(edPublic), 0o600))
+		keys, err := NewKeySet(context.Background(), DirKeys(dir))
+		require.NoError(t, err)
+
+		for id, sign := range map[string]struct{ alg, private string }{
+			"rsa":     {AlgorithmPS256, rsaPrivate},
+			"2022.11": {AlgorithmEdDSA, edPrivate},
+		} {
+			sk, err := NewSecretKeyAlg(id, sign.alg, sign.private)
+			require.NoError(t, err)
+			token, err := sk.Sign(NewAPIClaims("test", uuid.New()))
+			require.NoError(t, err)
+			require.NoError(t, keys.Verify(token, &APIClaims{}), id)
+		}
+		rs256, err := NewSecretKeyAlg("rsa", AlgorithmRS256, rsaPrivate)
+		require.NoError(t, err)
+		token, err := rs256.Sign(NewAPIClaims("test", uuid.New()))
+		require.NoError(t, err)
+		require.ErrorIs(t, keys.Verify(token, &APIClaims{}), ErrAlgorithm)
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "rsa.pem"), []byte(rsaPublic), 0o600))
+		require.Error(t, keys.Refresh(context.Background()))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "ec.RS256.pem"), []byte(ecPublic), 0o600))
+		require.NoError(t, os.Remove(filepath.Join(dir, "rsa.pem")))
+		require.ErrorIs(t, keys.Refresh(context.Background()), ErrAlgorithm)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		t.Parallel()
+
+		encode := func(b []byte) string {
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk{
+				{
+					KeyType:   "RSA",
+					ID:        "rsa",
+					Algorithm: AlgorithmPS256,
+					N:         encode(rsaKey.N.Bytes()),
+					E:         encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{KeyType: "OKP", ID: "ed", Curve: "Ed25519", X: encode(edKey.Public().(ed25519.PublicKey))},
+				{KeyType: "RSA", ID: "unsupported", Algorithm: "RS512"},
+			}})
+		}))
+		defer srv.Close()
+		keys, err := NewKeySet(context.Background(), JWKSKeys(srv.Client(), srv.URL))
+		require.NoError(t, err)
+
+		for id, sign := range map[string]struct{ alg, private string }{
+			"rsa": {AlgorithmPS256, rsaPrivate},
+			"ed":  {AlgorithmEdDSA, edPrivate},
+		} {
+			sk, err := NewSecretKeyAlg(id, sign.alg, sign.private)
+			require.NoError(t, err)
+			token, err := sk.Sign(NewAPIClaims("test", uuid.New()))
+			require.NoError(t, err)
+			require.NoError(t, keys.Verify(token, &APIClaims{}), id)
+		}
+		rs256, err := NewSecretKeyAlg("rsa", AlgorithmRS256, rsaPrivate)
+		require.NoError(t, err)
+		token, err := rs256.Sign(NewAPIClaims("test", uuid.New()))
+		require.NoError(t, err)
+		require.ErrorIs(t, keys.Verify(token, &APIClaims{}), ErrAlgorithm)
+	})
+}
@@ -1,34 +1,56 @@
 package auth
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"errors"
+	"fmt"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-const (
-	algorithm = "ES256"
-)
+// headerKeyID is the token header naming the key which signed it.
+const headerKeyID = "kid"
+
+// Verifier verifies tokens and decodes their claims, it is implemented by PublicKey and KeySet.
+type Verifier interface {
+	Verify(token string, claims jwt.Claims) error
+}
 
 type SecretKey struct {
-	key    *ecdsa.PrivateKey
+	id     string
+	key    crypto.Signer
 	method jwt.SigningMethod
 }
 
-func NewSecretKey(ecdsaKey string) (*SecretKey, error) {
-	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(ecdsaKey))
+// NewSecretKey returns the key signing tokens with the key ID in their kid header, an empty ID is not stamped.
+// The algorithm is the default one of the key type, see NewSecretKeyAlg.
+func NewSecretKey(id, key string) (*SecretKey, error) {
+	return NewSecretKeyAlg(id, "", key)
+}
+
+// NewSecretKeyAlg returns the key signing tokens with the algorithm, it has to suit the key type.
+// An empty alg selects the default algorithm of the key type.
+func NewSecretKeyAlg(id, alg, key string) (*SecretKey, error) {
+	signer, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	method, err := methodOf(signer.Public(), alg)
 	if err != nil {
 		return nil, err
 	}
 	return &SecretKey{
-		key:    key,
-		method: jwt.GetSigningMethod(algorithm),
+		id:     id,
+		key:    signer,
+		method: method,
 	}, nil
 }
 
 func (s *SecretKey) Sign(claims jwt.Claims) (string, error) {
 	token := jwt.NewWithClaims(s.method, claims)
+	if s.id != "" {
+		token.Header[headerKeyID] = s.id
+	}
 	signed, err := token.SignedString(s.key)
 	if err != nil {
 		return "", err
@@ -36,24 +58,58 @@ func (s *SecretKey) Sign(claims jwt.Claims) (string, error) {
 	return signed, nil
 }
 
+// PublicKey verifies tokens signed with a single algorithm, tokens with another alg header are rejected.
 type PublicKey struct {
-	key *ecdsa.PublicKey
+	key    crypto.PublicKey
+	method jwt.SigningMethod
+}
+
+// NewPublicKey parses a PEM encoded key, the algorithm is the default one of the key type, see NewPublicKeyAlg.
+func NewPublicKey(key string) (*PublicKey, error) {
+	return NewPublicKeyAlg("", key)
 }
 
-func NewPublicKey(ecdsaKey string) (*PublicKey, error) {
-	key, err := jwt.ParseECPublicKeyFromPEM([]byte(ecdsaKey))
+// NewPublicKeyAlg parses a PEM encoded key verifying tokens of the algorithm, it has to suit the key type.
+// An empty alg selects the default algorithm of the key type: ES256 for P-256 keys, RS256 for RSA keys
+// and EdDSA for Ed25519 keys.
+func NewPublicKeyAlg(alg, key string) (*PublicKey, error) {
+	parsed, err := parsePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return newPublicKey(parsed, alg)
+}
+
+func newPublicKey(key crypto.PublicKey, alg string) (*PublicKey, error) {
+	method, err := methodOf(key, alg)
 	if err != nil {
 		return nil, err
 	}
 	return &PublicKey{
-		key: key,
+		key:    key,
+		method: method,
 	}, nil
 }
 
 func (v *PublicKey) Verify(token string, claims jwt.Claims) error {
-	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return v.key, nil
-	})
+	return verify(token, claims, v.keyFunc)
+}
+
+func (v *PublicKey) keyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != v.method.Alg() {
+		return nil, fmt.Errorf("%w %s, the key verifies %s", ErrAlgorithm, token.Method.Alg(), v.method.Alg())
+	}
+	return v.key, nil
+}
+
+// verify parses the token and checks its claims and signature by the key returned by keyFunc.
+// Errors of keyFunc and claims are returned as is, as jwt.ValidationError cannot be unwrapped.
+func verify(token string, claims jwt.Claims, keyFunc jwt.Keyfunc) error {
+	parsed, err := jwt.ParseWithClaims(token, claims, keyFunc)
+	var verr *jwt.ValidationError
+	if errors.As(err, &verr) && verr.Inner != nil {
+		return verr.Inner
+	}
 	if err != nil {
 		return err
 	}
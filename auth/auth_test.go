@@ -28,25 +28,26 @@ MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAETrMd0Br7GOpE7US1jJ7LbL0L8vIi
 func TestAPIToken(t *testing.T) {
 	t.Parallel()
 
-	sk, err := NewSecretKey(secretKey)
+	sk, err := NewSecretKey("", secretKey)
 	require.NoError(t, err)
 	pk, err := NewPublicKey(publicKey)
 	require.NoError(t, err)
 
 	t.Run("Happy path", func(t *testing.T) {
 		userID := uuid.New()
-		token, err := sk.Sign(NewAPIClaims(userID))
+		token, err := sk.Sign(NewAPIClaims("test", userID))
 		require.NoError(t, err)
 		var claims APIClaims
 		err = pk.Verify(token, &claims)
 		require.NoError(t, err)
 		assert.Equal(t, userID, claims.UserID)
+		assert.Equal(t, "test", claims.TenantID)
 		assert.NotZero(t, claims.ExpiresAt)
 	})
 
 	t.Run("Invalid signature returns error", func(t *testing.T) {
 		userID := uuid.New()
-		token, err := sk.Sign(NewAPIClaims(userID))
+		token, err := sk.Sign(NewAPIClaims("test", userID))
 		require.NoError(t, err)
 		parts := strings.Split(token, ".")
 
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,21 +12,33 @@ const (
 	apiExpire = 15 * time.Minute
 )
 
+// ErrNoTenant is returned for tokens without the tenant_id claim.
+var ErrNoTenant = errors.New("tenant_id is missing")
+
 type APIClaims struct {
 	jwt.StandardClaims
-	UserID uuid.UUID `json:"uid"`
+	// TenantID isolates the data the token gives access to, it is required.
+	TenantID string    `json:"tenant_id"`
+	UserID   uuid.UUID `json:"uid"`
+	Roles    []string  `json:"roles,omitempty"`
+	Scopes   []string  `json:"scopes,omitempty"`
 }
 
-func NewAPIClaims(userID uuid.UUID) *APIClaims {
+func NewAPIClaims(tenantID string, userID uuid.UUID, scopes ...string) *APIClaims {
 	return &APIClaims{
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(apiExpire).Unix(),
 		},
-		UserID: userID,
+		TenantID: tenantID,
+		UserID:   userID,
+		Scopes:   scopes,
 	}
 }
 
 func (c APIClaims) Valid() error {
+	if c.TenantID == "" {
+		return ErrNoTenant
+	}
 	return c.StandardClaims.Valid()
 }
 
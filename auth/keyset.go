@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnknownKey is returned for tokens whose kid header names no key of the set.
+var ErrUnknownKey = errors.New("unknown key")
+
+// jwksLimit bounds the size of a fetched key set.
+const jwksLimit = 1 << 20
+
+// KeySource loads public keys by their IDs, which are the kid headers of the tokens they verify.
+type KeySource func(ctx context.Context) (map[string]*PublicKey, error)
+
+// StaticKeys is a source of fixed keys.
+func StaticKeys(keys map[string]*PublicKey) KeySource {
+	return func(context.Context) (map[string]*PublicKey, error) {
+		return keys, nil
+	}
+}
+
+// DirKeys loads PEM encoded public keys from *.pem files of the directory, the file name without
+// the extension is the key ID, e.g. 2022-11.pem verifies tokens with the kid 2022-11. A file named
+// <kid>.<alg>.pem sets the algorithm of the key, e.g. 2022-11.PS256.pem, otherwise the default
+// algorithm of the key type is used.
+func DirKeys(dir string) KeySource {
+	return func(context.Context) (map[string]*PublicKey, error) {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		keys := make(map[string]*PublicKey, len(files))
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			id, alg := keyFileName(filepath.Base(f))
+			key, err := NewPublicKeyAlg(alg, string(b))
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %s: %w", f, err)
+			}
+			if _, ok := keys[id]; ok {
+				return nil, fmt.Errorf("duplicated key %q in %s", id, dir)
+			}
+			keys[id] = key
+		}
+		return keys, nil
+	}
+}
+
+// dirAlgorithms are the algorithms which can be set by the name of a key file.
+var dirAlgorithms = []string{AlgorithmES256, AlgorithmRS256, AlgorithmPS256, AlgorithmEdDSA}
+
+// keyFileName returns the key ID and the algorithm of a key file, the algorithm is empty unless
+// the name ends with one, so that IDs containing dots are kept intact.
+func keyFileName(name string) (string, string) {
+	id := strings.TrimSuffix(name, ".pem")
+	ext := filepath.Ext(id)
+	for _, alg := range dirAlgorithms {
+		if ext == "."+alg {
+			return strings.TrimSuffix(id, ext), alg
+		}
+	}
+	return id, ""
+}
+
+// jwk is a JSON Web Key, see RFC 7517 and RFC 7518.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKSKeys fetches keys from a JSON Web Key Set URL. Keys not meant for signatures and keys of
+// unsupported types or algorithms are skipped, so that the set can hold keys of other services.
+func JWKSKeys(client *http.Client, url string) KeySource {
+	return func(ctx context.Context) (map[string]*PublicKey, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d of %s", resp.StatusCode, url)
+		}
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, jwksLimit)).Decode(&set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key set %s: %w", url, err)
+		}
+		keys := make(map[string]*PublicKey, len(set.Keys))
+		for _, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			key, err := k.publicKey()
+			if errors.Is(err, errUnsupportedKey) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q of %s: %w", k.ID, url, err)
+			}
+			keys[k.ID] = key
+		}
+		return keys, nil
+	}
+}
+
+var errUnsupportedKey = errors.New("unsupported key")
+
+// jwkAlgorithms are the algorithms of keys loaded from key sets, keys of other ones are skipped.
+var jwkAlgorithms = []string{"", AlgorithmES256, AlgorithmRS256, AlgorithmPS256, AlgorithmEdDSA}
+
+// publicKey returns the key verifying tokens of its alg, or of the default algorithm of the key type.
+func (k jwk) publicKey() (*PublicKey, error) {
+	supported := false
+	for _, alg := range jwkAlgorithms {
+		supported = supported || alg == k.Algorithm
+	}
+	if !supported {
+		return nil, errUnsupportedKey
+	}
+
+	var key crypto.PublicKey
+	switch {
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, y, err := decodeInts(k.X, k.Y)
+		if err != nil {
+			return nil, err
+		}
+		ec := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !ec.Curve.IsOnCurve(ec.X, ec.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		key = ec
+	case k.KeyType == "RSA":
+		n, e, err := decodeInts(k.N, k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key size")
+		}
+		key = ed25519.PublicKey(x)
+	default:
+		return nil, errUnsupportedKey
+	}
+	return newPublicKey(key, k.Algorithm)
+}
+
+// decodeInts decodes a pair of base64url encoded big-endian integers.
+func decodeInts(a, b string) (*big.Int, *big.Int, error) {
+	x, err := decodeInt(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := decodeInt(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, y, nil
+}
+
+func decodeInt(v string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// MergeKeys loads keys of all the sources, a key ID cannot be used by two of them.
+func MergeKeys(sources ...KeySource) KeySource {
+	return func(ctx context.Context) (map[string]*PublicKey, error) {
+		merged := make(map[string]*PublicKey)
+		for _, source := range sources {
+			keys, err := source(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for id, key := range keys {
+				if _, ok := merged[id]; ok {
+					return nil, fmt.Errorf("duplicated key %q", id)
+				}
+				merged[id] = key
+			}
+		}
+		return merged, nil
+	}
+}
+
+// KeySet verifies tokens with the key named by their kid header, tokens without it are verified
+// by the key with the empty ID. Keys are cached until the source is refreshed, so that during
+// a rotation the new key is published before tokens are signed with it and the old one is kept
+// until its tokens expire.
+type KeySet struct {
+	source KeySource
+	mu     sync.RWMutex
+	keys   map[string]*PublicKey
+}
+
+// NewKeySet returns the set of keys loaded from the source.
+func NewKeySet(ctx context.Context, source KeySource) (*KeySet, error) {
+	s := &KeySet{
+		source: source,
+	}
+	err := s.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh reloads the keys, they are left intact when the source fails.
+func (s *KeySet) Refresh(ctx context.Context) error {
+	keys, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no keys loaded")
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the keys every interval until the context is done.
+func (s *KeySet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := s.Refresh(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to refresh keys, keeping the cached ones.")
+		}
+	}
+}
+
+func (s *KeySet) Verify(token string, claims jwt.Claims) error {
+	return verify(token, claims, s.keyFunc)
+}
+
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header[headerKeyID].(string)
+	s.mu.RLock()
+	key, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key.keyFunc(token)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newKey returns a signing key with the ID and its public key in PEM.
+func newKey(t *testing.T, id string) (*SecretKey, *ecdsa.PublicKey, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	sk, err := NewSecretKey(id, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return sk, &key.PublicKey, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestKeySet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	oldSK, _, oldPEM := newKey(t, "old")
+	newSK, newPub, newPEM := newKey(t, "new")
+	sign := func(sk *SecretKey) string {
+		token, err := sk.Sign(NewAPIClaims("test", uuid.New()))
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("Static keys overlap during rotation", func(t *testing.T) {
+		oldPK, err := NewPublicKey(oldPEM)
+		require.NoError(t, err)
+		newPK, err := NewPublicKey(newPEM)
+		require.NoError(t, err)
+		keys, err := NewKeySet(ctx, StaticKeys(map[string]*PublicKey{"old": oldPK, "new": newPK}))
+		require.NoError(t, err)
+
+		require.NoError(t, keys.Verify(sign(oldSK), &APIClaims{}))
+		require.NoError(t, keys.Verify(sign(newSK), &APIClaims{}))
+		other, _, _ := newKey(t, "other")
+		assert.ErrorIs(t, keys.Verify(sign(other), &APIClaims{}), ErrUnknownKey)
+		// A known kid does not make a token signed by another key valid.
+		forged, _, _ := newKey(t, "new")
+		assert.Error(t, keys.Verify(sign(forged), &APIClaims{}))
+	})
+
+	t.Run("Tokens without kid use the key without ID", func(t *testing.T) {
+		sk, err := NewSecretKey("", secretKey)
+		require.NoError(t, err)
+		pk, err := NewPublicKey(publicKey)
+		require.NoError(t, err)
+		keys, err := NewKeySet(ctx, StaticKeys(map[string]*PublicKey{"": pk}))
+		require.NoError(t, err)
+		require.NoError(t, keys.Verify(sign(sk), &APIClaims{}))
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "old.pem"), []byte(oldPEM), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0o600))
+		keys, err := NewKeySet(ctx, DirKeys(dir))
+		require.NoError(t, err)
+		require.NoError(t, keys.Verify(sign(oldSK), &APIClaims{}))
+		assert.ErrorIs(t, keys.Verify(sign(newSK), &APIClaims{}), ErrUnknownKey)
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "new.pem"), []byte(newPEM), 0o600))
+		require.NoError(t, keys.Refresh(ctx))
+		require.NoError(t, keys.Verify(sign(newSK), &APIClaims{}))
+	})
+
+	t.Run("JWKS is cached when refresh fails", func(t *testing.T) {
+		var failing int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk{
+				{
+					KeyType: "EC",
+					ID:      "new",
+					Use:     "sig",
+					Curve:   "P-256",
+					X:       base64.RawURLEncoding.EncodeToString(newPub.X.FillBytes(make([]byte, 32))),
+					Y:       base64.RawURLEncoding.EncodeToString(newPub.Y.FillBytes(make([]byte, 32))),
+				},
+				{KeyType: "oct", ID: "symmetric"},
+				{KeyType: "EC", ID: "encryption", Use: "enc", Curve: "P-256"},
+			}})
+		}))
+		defer srv.Close()
+
+		keys, err := NewKeySet(ctx, JWKSKeys(srv.Client(), srv.URL))
+		require.NoError(t, err)
+		require.NoError(t, keys.Verify(sign(newSK), &APIClaims{}))
+
+		atomic.StoreInt32(&failing, 1)
+		require.Error(t, keys.Refresh(ctx))
+		require.NoError(t, keys.Verify(sign(newSK), &APIClaims{}))
+	})
+
+	t.Run("Duplicated key IDs are rejected", func(t *testing.T) {
+		pk, err := NewPublicKey(publicKey)
+		require.NoError(t, err)
+		static := StaticKeys(map[string]*PublicKey{"key": pk})
+		_, err = NewKeySet(ctx, MergeKeys(static, static))
+		require.Error(t, err)
+	})
+}
+
+func TestSecretKey_Sign(t *testing.T) {
+	t.Parallel()
+
+	sk, _, _ := newKey(t, "2022-11")
+	token, err := sk.Sign(NewAPIClaims("test", uuid.New()))
+	require.NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &APIClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2022-11", parsed.Header["kid"])
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scopes of API tokens. Higher scopes imply the lower ones: admin implies write, write implies read.
+const (
+	ScopeCompaniesRead  = "companies:read"
+	ScopeCompaniesWrite = "companies:write"
+	ScopeCompaniesAdmin = "companies:admin"
+)
+
+// Roles are named sets of scopes, granted by the roles claim.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleScopes = map[string][]string{
+	RoleViewer: {ScopeCompaniesRead},
+	RoleEditor: {ScopeCompaniesWrite},
+	RoleAdmin:  {ScopeCompaniesAdmin},
+}
+
+var impliedScopes = map[string][]string{
+	ScopeCompaniesAdmin: {ScopeCompaniesWrite, ScopeCompaniesRead},
+	ScopeCompaniesWrite: {ScopeCompaniesRead},
+}
+
+var (
+	// ErrUnauthenticated is returned for missing and invalid tokens.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned for valid tokens lacking a required scope.
+	ErrForbidden = errors.New("insufficient scope")
+)
+
+// Authorizer verifies tokens of requests and checks the scopes they grant.
+type Authorizer struct {
+	verifier Verifier
+}
+
+func NewAuthorizer(verifier Verifier) *Authorizer {
+	return &Authorizer{
+		verifier: verifier,
+	}
+}
+
+// Authorize returns the claims of the request token, provided the token grants all the scopes.
+// Claims of a valid token are returned together with ErrForbidden.
+func (a *Authorizer) Authorize(r *http.Request, scopes ...string) (APIClaims, error) {
+	var claims APIClaims
+	err := a.verifier.Verify(Token(r), &claims)
+	if err != nil {
+		return APIClaims{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	missing := claims.Missing(scopes...)
+	if len(missing) > 0 {
+		return claims, fmt.Errorf("%w: %s required", ErrForbidden, strings.Join(missing, " "))
+	}
+	return claims, nil
+}
+
+// Granted returns the scopes of the token: the scopes claim, the scopes of its roles and the implied ones.
+func (c APIClaims) Granted() map[string]bool {
+	granted := make(map[string]bool)
+	var grant func(scope string)
+	grant = func(scope string) {
+		if granted[scope] {
+			return
+		}
+		granted[scope] = true
+		for _, s := range impliedScopes[scope] {
+			grant(s)
+		}
+	}
+	for _, s := range c.Scopes {
+		grant(s)
+	}
+	for _, r := range c.Roles {
+		for _, s := range roleScopes[r] {
+			grant(s)
+		}
+	}
+	return granted
+}
+
+// Missing returns the scopes the token does not grant.
+func (c APIClaims) Missing(scopes ...string) []string {
+	granted := c.Granted()
+	var missing []string
+	for _, s := range scopes {
+		if !granted[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+type claimsKey struct{}
+
+func WithClaims(ctx context.Context, c APIClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// ClaimsFrom returns the claims of the authorized request, ok is false for anonymous ones.
+func ClaimsFrom(ctx context.Context) (c APIClaims, ok bool) {
+	c, ok = ctx.Value(claimsKey{}).(APIClaims)
+	return c, ok
+}
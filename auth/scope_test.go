@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIClaims_Granted(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, map[string]bool{ScopeCompaniesRead: true}, APIClaims{Scopes: []string{ScopeCompaniesRead}}.Granted())
+	assert.Equal(t,
+		map[string]bool{ScopeCompaniesRead: true, ScopeCompaniesWrite: true},
+		APIClaims{Scopes: []string{ScopeCompaniesWrite}}.Granted(),
+	)
+	assert.Equal(t,
+		map[string]bool{ScopeCompaniesRead: true, ScopeCompaniesWrite: true, ScopeCompaniesAdmin: true},
+		APIClaims{Roles: []string{RoleAdmin}}.Granted(),
+	)
+	assert.Empty(t, APIClaims{Roles: []string{"unknown"}}.Granted())
+	assert.Equal(t,
+		[]string{ScopeCompaniesAdmin},
+		APIClaims{Roles: []string{RoleEditor}}.Missing(ScopeCompaniesRead, ScopeCompaniesAdmin),
+	)
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	t.Parallel()
+
+	sk, err := NewSecretKey("", secretKey)
+	require.NoError(t, err)
+	pk, err := NewPublicKey(publicKey)
+	require.NoError(t, err)
+	authz := NewAuthorizer(pk)
+
+	request := func(claims *APIClaims) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if claims != nil {
+			token, err := sk.Sign(claims)
+			require.NoError(t, err)
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	t.Run("Granted scope", func(t *testing.T) {
+		userID := uuid.New()
+		claims, err := authz.Authorize(request(NewAPIClaims("test", userID, ScopeCompaniesWrite)), ScopeCompaniesRead)
+		require.NoError(t, err)
+		assert.Equal(t, userID, claims.UserID)
+	})
+
+	t.Run("Granted role", func(t *testing.T) {
+		claims := NewAPIClaims("test", uuid.New())
+		claims.Roles = []string{RoleAdmin}
+		_, err := authz.Authorize(request(claims), ScopeCompaniesWrite, ScopeCompaniesAdmin)
+		require.NoError(t, err)
+	})
+
+	t.Run("Missing scope is forbidden", func(t *testing.T) {
+		userID := uuid.New()
+		claims, err := authz.Authorize(request(NewAPIClaims("test", userID, ScopeCompaniesRead)), ScopeCompaniesWrite)
+		require.ErrorIs(t, err, ErrForbidden)
+		assert.Contains(t, err.Error(), ScopeCompaniesWrite)
+		assert.Equal(t, userID, claims.UserID)
+	})
+
+	t.Run("Missing token is unauthenticated", func(t *testing.T) {
+		_, err := authz.Authorize(request(nil))
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("Expired token is unauthenticated", func(t *testing.T) {
+		claims := NewAPIClaims("test", uuid.New(), ScopeCompaniesAdmin)
+		claims.ExpiresAt = 1
+		_, err := authz.Authorize(request(claims), ScopeCompaniesRead)
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("Token without tenant is unauthenticated", func(t *testing.T) {
+		_, err := authz.Authorize(request(NewAPIClaims("", uuid.New(), ScopeCompaniesAdmin)), ScopeCompaniesRead)
+		require.ErrorIs(t, err, ErrUnauthenticated)
+		assert.Contains(t, err.Error(), ErrNoTenant.Error())
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/auth"
+	"github.com/a-romancev/crud_task/company"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatch(t *testing.T) {
+	t.Parallel()
+
+	for header, expected := range map[string]int64{
+		"":      0,
+		"*":     0,
+		`"3"`:   3,
+		` "3" `: 3,
+	} {
+		r := httptest.NewRequest(http.MethodPatch, "/", nil)
+		r.Header.Set("If-Match", header)
+		version, err := ifMatch(r)
+		require.NoError(t, err, header)
+		assert.Equal(t, expected, version, header)
+	}
+
+	for _, header := range []string{`W/"3"`, `3`, `"0"`, `"-1"`, `"three"`} {
+		r := httptest.NewRequest(http.MethodPatch, "/", nil)
+		r.Header.Set("If-Match", header)
+		_, err := ifMatch(r)
+		assert.Error(t, err, header)
+	}
+}
+
+func TestHandler_ConditionalGet(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	modified := time.Date(2022, 11, 1, 12, 30, 15, 500, time.UTC)
+	do := testServer(t, &fakeRepo{companies: []company.Company{{ID: id, Name: "Acme", Version: 2, UpdatedAt: modified}}})
+	path := "/v1/companies/" + id.String()
+
+	resp := do(http.MethodGet, path, "", nil, auth.ScopeCompaniesRead)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "Tue, 01 Nov 2022 12:30:15 GMT", resp.Header.Get("Last-Modified"))
+
+	for name, tc := range map[string]struct {
+		header http.Header
+		status int
+	}{
+		"Matching tag":            {http.Header{"If-None-Match": {`"2"`}}, http.StatusNotModified},
+		"Weak comparison":         {http.Header{"If-None-Match": {`"1", W/"2"`}}, http.StatusNotModified},
+		"Any tag":                 {http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		"Other tag":               {http.Header{"If-None-Match": {`"1"`}}, http.StatusOK},
+		"Not modified since":      {http.Header{"If-Modified-Since": {"Tue, 01 Nov 2022 12:30:15 GMT"}}, http.StatusNotModified},
+		"Modified since":          {http.Header{"If-Modified-Since": {"Tue, 01 Nov 2022 12:30:14 GMT"}}, http.StatusOK},
+		"Tag takes precedence":    {http.Header{"If-None-Match": {`"1"`}, "If-Modified-Since": {"Tue, 01 Nov 2022 12:30:15 GMT"}}, http.StatusOK},
+		"Invalid date is ignored": {http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+	} {
+		resp := do(http.MethodGet, path, "", tc.header, auth.ScopeCompaniesRead)
+		defer resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, name)
+		assert.Equal(t, `"2"`, resp.Header.Get("ETag"), name)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"log"
+	"time"
 
+	"github.com/a-romancev/crud_task/internal/event"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 )
@@ -12,19 +14,50 @@ type Mongo struct {
 	Password string `mapstructure:"password"`
 	Host     string `mapstructure:"host"`
 	Database string `mapstructure:"database"`
+	// Retention of soft deleted companies, zero keeps them forever.
+	Retention time.Duration `mapstructure:"retention"`
 }
 
-type Kafka struct {
-	Servers []string `mapstructure:"servers"`
-	Topic   string   `mapstructure:"topic"`
+// Event selects the sink of company events: kafka (default), stdout, file or none.
+type Event struct {
+	Sink string         `mapstructure:"sink"`
+	File event.FileConf `mapstructure:"file"`
+}
+
+type Cursor struct {
+	Secret string        `mapstructure:"secret"`
+	TTL    time.Duration `mapstructure:"ttl"`
+}
+
+// Keys verify tokens by their kid header, keys of all the sources are merged. Keys of the directory
+// and the JWKS URL are reloaded every Refresh, so that signing keys can be rotated without a redeploy.
+type Keys struct {
+	Static  []Key         `mapstructure:"static"`
+	Dir     string        `mapstructure:"dir"`
+	JWKSURL string        `mapstructure:"jwks_url"`
+	Refresh time.Duration `mapstructure:"refresh"`
+}
+
+// Key verifies tokens with its kid header. Alg is ES256, RS256, PS256 or EdDSA and it has to suit the key type,
+// an empty one is the default of the key type.
+type Key struct {
+	ID        string `mapstructure:"kid"`
+	Algorithm string `mapstructure:"alg"`
+	PublicKey string `mapstructure:"public_key"`
 }
 
 type Config struct {
-	ListenWebAddress string `mapstructure:"listen"`
-	LogLevel         string `mapstructure:"loglevel"`
-	Kafka            Kafka  `mapstructure:"kafka"`
-	Mongo            Mongo  `mapstructure:"mongo"`
-	PublicKey        string `mapstructure:"public_key"`
+	ListenWebAddress string          `mapstructure:"listen"`
+	LogLevel         string          `mapstructure:"loglevel"`
+	Event            Event           `mapstructure:"event"`
+	Kafka            event.KafkaConf `mapstructure:"kafka"`
+	Mongo            Mongo           `mapstructure:"mongo"`
+	Cursor           Cursor          `mapstructure:"cursor"`
+
+	// PublicKey verifies tokens without the kid header, signed with PublicKeyAlg, see Key.
+	PublicKey    string `mapstructure:"public_key"`
+	PublicKeyAlg string `mapstructure:"public_key_alg"`
+	Keys         Keys   `mapstructure:"keys"`
 }
 
 func (c Config) WithFile(confPath string) Config {
@@ -51,9 +84,17 @@ func (c Config) Validate() error {
 	if c.LogLevel == "" {
 		return errors.New("loglevel not set")
 	}
-	if c.PublicKey == "" {
+	if c.PublicKey == "" && len(c.Keys.Static) == 0 && c.Keys.Dir == "" && c.Keys.JWKSURL == "" {
 		return errors.New("publicKey not set")
 	}
+	for i, k := range c.Keys.Static {
+		if k.ID == "" || k.PublicKey == "" {
+			return errors.Errorf("static key %d: kid and public_key not set", i)
+		}
+	}
+	if (c.Keys.Dir != "" || c.Keys.JWKSURL != "") && c.Keys.Refresh <= 0 {
+		return errors.New("keys refresh not set")
+	}
 	if c.Mongo.Host == "" {
 		return errors.New("mongoDB host not set")
 	}
@@ -66,11 +107,30 @@ func (c Config) Validate() error {
 	if c.Mongo.Password == "" {
 		return errors.New("mongoDB password not set")
 	}
-	if len(c.Kafka.Servers) < 1 {
-		return errors.New("kafka server not set")
+	if c.Mongo.Retention < 0 {
+		return errors.New("mongoDB retention cannot be negative")
+	}
+	switch c.Event.Sink {
+	case "", event.SinkKafka:
+		if err := c.Kafka.Validate(); err != nil {
+			return err
+		}
+	case event.SinkFile:
+		if c.Event.File.Path == "" {
+			return errors.New("event file path not set")
+		}
+		if c.Event.File.MaxSize < 0 || c.Event.File.MaxBackups < 0 {
+			return errors.New("event file rotation cannot be negative")
+		}
+	case event.SinkStdout, event.SinkNop:
+	default:
+		return errors.Errorf("unknown event sink %q", c.Event.Sink)
+	}
+	if c.Cursor.Secret == "" {
+		return errors.New("cursor secret not set")
 	}
-	if c.Kafka.Topic == "" {
-		return errors.New("kafka topic not set")
+	if c.Cursor.TTL <= 0 {
+		return errors.New("cursor ttl not set")
 	}
 	return nil
 }
@@ -3,218 +3,602 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"github.com/a-romancev/crud_task/auth"
 	"github.com/a-romancev/crud_task/company"
 	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/a-romancev/crud_task/webhook"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const bodySizeLimit = 1000
+const (
+	bodySizeLimit = 1000
+	// collaboratorsSizeLimit fits the longest list of collaborators.
+	collaboratorsSizeLimit = 5000
+)
 
 type Repo interface {
 	Create(ctx context.Context, request company.Company) (company.Company, error)
 	Fetch(ctx context.Context, lookup company.Lookup) ([]company.Company, error)
+	Count(ctx context.Context, lookup company.Lookup) (int64, error)
 	FetchOne(ctx context.Context, lookup company.Lookup) (company.Company, error)
-	UpdateOne(ctx context.Context, lookup company.Lookup, request company.Company) (company.Company, error)
-	DeleteOne(ctx context.Context, lookup company.Lookup) error
-}
-type Producer interface {
-	Produce(m string) error
+	UpdateOne(ctx context.Context, lookup company.Lookup, patch company.Patch) (company.Company, error)
+	DeleteOne(ctx context.Context, lookup company.Lookup) (company.Company, error)
+	Restore(ctx context.Context, lookup company.Lookup) (company.Company, error)
+	SetCollaborators(ctx context.Context, lookup company.Lookup, collaborators []uuid.UUID) (company.Company, error)
+	History(ctx context.Context, lookup company.HistoryLookup) ([]company.Change, error)
+	CountHistory(ctx context.Context, lookup company.HistoryLookup) (int64, error)
+	AsOf(ctx context.Context, id uuid.UUID, at time.Time) (company.Company, error)
 }
-
 type Handler struct {
-	router        http.Handler
-	repo          Repo
-	eventProducer Producer
-	pk            *auth.PublicKey
+	router   http.Handler
+	repo     Repo
+	webhooks WebhookRepo
+	authz    *auth.Authorizer
+	cursors  *company.CursorCodec
+	overload event.Overloader
 }
 
-func NewHandler(repo Repo, producer event.Producer, pk *auth.PublicKey) *Handler {
+func NewHandler(repo Repo, webhooks WebhookRepo, verifier auth.Verifier, cursors *company.CursorCodec, overload event.Overloader) *Handler {
 	h := Handler{
-		repo:          repo,
-		eventProducer: producer,
-		pk:            pk,
+		repo:     repo,
+		webhooks: webhooks,
+		authz:    auth.NewAuthorizer(verifier),
+		cursors:  cursors,
+		overload: overload,
 	}
 	r := httprouter.New()
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newAPIError(r, http.StatusNotFound, "Not found.", errors.New(r.URL.Path)).Write(w)
+	})
+	r.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newAPIError(r, http.StatusMethodNotAllowed, "Method not allowed.", errors.New(r.Method)).Write(w)
+	})
 	r.GET("/health", health)
-	r.GET("/v1/companies", h.fetchCompanies)
-	r.POST("/v1/companies", h.createCompany)
-	r.GET("/v1/companies/:id", h.fetchCompany)
-	r.PATCH("/v1/companies/:id", h.updateCompany)
-	r.DELETE("/v1/companies/:id", h.deleteCompany)
+	r.GET("/debug/vars", h.scoped(debugVars, auth.ScopeCompaniesAdmin))
+	r.GET("/v1/companies", h.scoped(h.fetchCompanies, auth.ScopeCompaniesRead))
+	r.POST("/v1/companies", h.throttled(h.scoped(h.createCompany, auth.ScopeCompaniesWrite)))
+	r.GET("/v1/companies/:id", h.scoped(identified(h.fetchCompany), auth.ScopeCompaniesRead))
+	r.PATCH("/v1/companies/:id", h.throttled(h.scoped(identified(h.updateCompany), auth.ScopeCompaniesWrite)))
+	r.DELETE("/v1/companies/:id", h.throttled(h.scoped(identified(h.deleteCompany), auth.ScopeCompaniesWrite)))
+	r.POST("/v1/companies/:id/restore", h.throttled(h.scoped(identified(h.restoreCompany), auth.ScopeCompaniesWrite)))
+	r.PUT("/v1/companies/:id/collaborators", h.throttled(h.scoped(identified(h.setCollaborators), auth.ScopeCompaniesWrite)))
+	r.GET("/v1/companies/:id/history", h.scoped(identified(h.fetchHistory), auth.ScopeCompaniesRead))
+	r.GET("/v1/webhooks", h.scoped(h.fetchWebhooks, auth.ScopeCompaniesAdmin))
+	r.POST("/v1/webhooks", h.scoped(h.createWebhook, auth.ScopeCompaniesAdmin))
+	r.GET("/v1/webhooks/:id", h.scoped(identified(h.fetchWebhook), auth.ScopeCompaniesAdmin))
+	r.PATCH("/v1/webhooks/:id", h.scoped(identified(h.updateWebhook), auth.ScopeCompaniesAdmin))
+	r.DELETE("/v1/webhooks/:id", h.scoped(identified(h.deleteWebhook), auth.ScopeCompaniesAdmin))
+	r.GET("/v1/webhooks/:id/deliveries", h.scoped(identified(h.fetchDeliveries), auth.ScopeCompaniesAdmin))
 
 	h.router = r
 	return &h
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx := trace(w, r)
 
 	defer func() {
 		if err := recover(); err != nil {
 			log.Ctx(ctx).Error().Interface("error", err).Msg("Recovered server error.")
-			w.WriteHeader(http.StatusInternalServerError)
+			// The panic is logged only, it may tell details of the server.
+			newAPIError(r, http.StatusInternalServerError, "Unexpected server error.", errors.New("the request failed")).Write(w)
 		}
 	}()
 	h.router.ServeHTTP(w, r.WithContext(ctx))
 }
 
+// trace puts the request ID and the trace context of the request into its context, so that they are passed
+// on to events. A request ID is generated unless the client sent one, it is echoed in the response.
+func trace(w http.ResponseWriter, r *http.Request) context.Context {
+	t := event.Trace{
+		RequestID:   r.Header.Get("X-Request-ID"),
+		TraceParent: r.Header.Get("traceparent"),
+		TraceState:  r.Header.Get("tracestate"),
+	}
+	if t.RequestID == "" {
+		t.RequestID = uuid.NewString()
+	}
+	w.Header().Set("X-Request-ID", t.RequestID)
+
+	ctx := log.Ctx(r.Context()).With().Str("request_id", t.RequestID).Logger().WithContext(r.Context())
+	return event.WithTrace(ctx, t)
+}
+
+// throttled rejects writes while their events cannot be published fast enough.
+func (h *Handler) throttled(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if h.overload.Overloaded() {
+			w.Header().Set("Retry-After", "1")
+			newAPIError(r, http.StatusServiceUnavailable, "Too many pending events.", event.ErrQueueFull).Write(w)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// scoped authorizes requests of the route, their token has to grant all the scopes.
+// Every route touching companies or webhooks is scoped, as the token tells the tenant of the request.
+func (h *Handler) scoped(next httprouter.Handle, scopes ...string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		r, err := h.authorize(r, scopes...)
+		if err != nil {
+			authError(w, r, err, scopes)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// debugVars publishes expvar counters. They describe the whole service and include its command line,
+// so they are served to admins only.
+func debugVars(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	expvar.Handler().ServeHTTP(w, r)
+}
+
 func health(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
 	_, _ = w.Write([]byte("ok"))
 }
 
+type paging struct {
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextOffset *int   `json:"next_offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type companiesPage struct {
+	Data   []company.Company `json:"data"`
+	Paging paging            `json:"paging"`
+}
+
 func (h *Handler) fetchCompanies(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	fetched, err := h.repo.Fetch(context.Background(), company.Lookup{})
+	lookup, err := parseListQuery(r.URL.Query(), h.cursors)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to fetch companies.")
-		newAPIError(http.StatusBadRequest, "Failed to fetch companies.", err).Write(w)
+		newAPIError(r, http.StatusBadRequest, "Invalid query.", err).Write(w)
+		return
+	}
+	lookup.IncludeDeleted, err = h.includeDeleted(r)
+	if err != nil {
+		authError(w, r, err, []string{auth.ScopeCompaniesAdmin})
+		return
+	}
+	if r.URL.Query().Get("owner") == ownerMe {
+		lookup.OwnerID = company.ActorFrom(r.Context()).UserID
+	}
+	// One extra company tells whether there is a next page.
+	lookup.Limit++
+	fetched, err := h.repo.Fetch(r.Context(), lookup)
+	lookup.Limit--
+	if err != nil {
+		newDomainError(r, "Failed to fetch companies.", err).Write(w)
+		return
+	}
+	total, err := h.repo.Count(r.Context(), lookup)
+	if err != nil {
+		newDomainError(r, "Failed to count companies.", err).Write(w)
 		return
 	}
-	apiResponse{Code: http.StatusOK, Body: fetched}.Write(w)
-}
 
-func (h *Handler) createCompany(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	var claims auth.APIClaims
-	err := h.pk.Verify(auth.Token(r), &claims)
+	more := len(fetched) > lookup.Limit
+	if more {
+		fetched = fetched[:lookup.Limit]
+	}
+	page := companiesPage{
+		Data: fetched,
+		Paging: paging{
+			Total: total,
+			Limit: lookup.Limit,
+		},
+	}
+	if page.Data == nil {
+		page.Data = []company.Company{}
+	}
+	if more {
+		page.Paging.NextCursor, err = h.cursors.Encode(lookup.Next(fetched))
+		if err != nil {
+			log.Ctx(r.Context()).Error().Err(err).Msg("Failed to encode cursor.")
+			newAPIError(r, http.StatusInternalServerError, "Failed to fetch companies.", err).Write(w)
+			return
+		}
+		if lookup.After == nil {
+			next := lookup.Offset + len(fetched)
+			page.Paging.NextOffset = &next
+		}
+	}
+	if lookup.After == nil {
+		page.Paging.Offset = lookup.Offset
+	}
+
+	// The cursor is left out of the entity tag as its expiry changes on every request.
+	unsigned := page
+	unsigned.Paging.NextCursor = ""
+	body, err := json.Marshal(unsigned)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to encode companies.")
+		newAPIError(r, http.StatusInternalServerError, "Failed to fetch companies.", err).Write(w)
+		return
+	}
+	// Lists have no Last-Modified, the newest company of a page does not change when a company leaves it,
+	// e.g. when deleted, so If-Modified-Since would return stale pages.
+	if notModified(w, r, weakETag(body), time.Time{}) {
 		return
 	}
+	apiResponse{Code: http.StatusOK, Body: page}.Write(w)
+}
+
+func (h *Handler) createCompany(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var cmp company.Company
-	err = json.NewDecoder(io.LimitReader(r.Body, bodySizeLimit)).Decode(&cmp)
+	err := json.NewDecoder(io.LimitReader(r.Body, bodySizeLimit)).Decode(&cmp)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Invalid company data.")
-		newAPIError(http.StatusBadRequest, "Invalid company data.", err).Write(w)
+		newAPIError(r, http.StatusBadRequest, "Invalid company data.", err).Write(w)
 		return
 	}
 	cmp.ID = uuid.New()
 	err = cmp.Validate()
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Invalid company data.")
-		newAPIError(http.StatusBadRequest, "Invalid company data", err).Write(w)
+		newDomainError(r, "Invalid company data.", err).Write(w)
 		return
 	}
 	created, err := h.repo.Create(r.Context(), cmp)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Company creation failed.")
-		newAPIError(http.StatusBadRequest, "Company creation failed.", err).Write(w)
+		newDomainError(r, "Company creation failed.", err).Write(w)
 		return
 	}
-	err = h.report(cmp, "added")
-	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to report event.")
-	}
+	w.Header().Set("ETag", etag(created))
 	apiResponse{Code: http.StatusCreated, Body: created}.Write(w)
 }
 
 func (h *Handler) fetchCompany(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	uid, err := uuid.Parse(id)
-	fetched, err := h.repo.FetchOne(context.Background(), company.Lookup{ID: uid})
+	includeDeleted, err := h.includeDeleted(r)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to fetch companies.")
-		newAPIError(http.StatusBadRequest, "Failed to fetch companies.", err).Write(w)
+		authError(w, r, err, []string{auth.ScopeCompaniesAdmin})
+		return
+	}
+	fetched, err := h.repo.FetchOne(r.Context(), company.Lookup{ID: pathID(p), IncludeDeleted: includeDeleted})
+	if err != nil {
+		newDomainError(r, "Failed to fetch company.", err).Write(w)
+		return
+	}
+	if notModified(w, r, etag(fetched), fetched.UpdatedAt) {
 		return
 	}
 	apiResponse{Code: http.StatusOK, Body: fetched}.Write(w)
 }
 
 func (h *Handler) deleteCompany(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	uid, err := uuid.Parse(id)
+	version, err := ifMatch(r)
+	if err != nil {
+		newAPIError(r, http.StatusPreconditionFailed, "Precondition failed.", err).Write(w)
+		return
+	}
+	_, err = h.repo.DeleteOne(r.Context(), company.Lookup{ID: pathID(p), Version: version})
+	if err != nil {
+		newDomainError(r, "Company deletion failed.", err).Write(w)
+		return
+	}
+	apiResponse{Code: http.StatusOK, Body: ""}.Write(w)
+}
+
+func (h *Handler) updateCompany(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	mt := mediaType(r)
+	switch mt {
+	case "", "application/json", "application/merge-patch+json", "application/json-patch+json":
+	default:
+		newAPIError(r, http.StatusUnsupportedMediaType, "Unsupported patch format.", errors.New(mt)).Write(w)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		newAPIError(r, http.StatusPreconditionFailed, "Precondition failed.", err).Write(w)
+		return
+	}
+	doc, err := io.ReadAll(io.LimitReader(r.Body, bodySizeLimit))
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid company data.", err).Write(w)
+		return
+	}
 
-	var claims auth.APIClaims
-	err = h.pk.Verify(auth.Token(r), &claims)
+	uid := pathID(p)
+	current, err := h.repo.FetchOne(r.Context(), company.Lookup{ID: uid})
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		newDomainError(r, "Failed to fetch company.", err).Write(w)
 		return
 	}
+	if version != 0 && current.Version != version {
+		newDomainError(r, "Precondition failed.", company.ErrVersionMismatch).Write(w)
+		return
+	}
+	var (
+		cmp    company.Company
+		expect map[string]interface{}
+	)
+	if mt == "application/json-patch+json" {
+		cmp, expect, err = company.JSONPatch(current, doc)
+	} else {
+		cmp, err = company.MergePatch(current, doc)
+	}
+	if errors.Is(err, company.ErrTestFailed) {
+		newDomainError(r, "Patch test failed.", err).Write(w)
+		return
+	}
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid company data.", err).Write(w)
+		return
+	}
+	err = cmp.Validate()
+	if err != nil {
+		newDomainError(r, "Invalid company data.", err).Write(w)
+		return
+	}
+	patch := company.Diff(current, cmp)
+	patch.Expect = expect
+	// The patch is the difference to the current version, it must not apply to a later one.
+	updated, err := h.repo.UpdateOne(r.Context(), company.Lookup{ID: uid, Version: current.Version}, patch)
+	if version == 0 && errors.Is(err, company.ErrVersionMismatch) {
+		newAPIError(r, http.StatusConflict, "Company changed concurrently, retry.", err).Write(w)
+		return
+	}
+	if err != nil {
+		newDomainError(r, "Company update failed.", err).Write(w)
+		return
+	}
+	w.Header().Set("ETag", etag(updated))
+	apiResponse{Code: http.StatusCreated, Body: updated}.Write(w)
+}
 
-	err = h.repo.DeleteOne(r.Context(), company.Lookup{ID: uid})
+func (h *Handler) restoreCompany(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	version, err := ifMatch(r)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Company creation failed.")
-		newAPIError(http.StatusBadRequest, "Company creation failed.", err).Write(w)
+		newAPIError(r, http.StatusPreconditionFailed, "Precondition failed.", err).Write(w)
 		return
 	}
-	cmp := company.Company{ID: uid}
-	err = h.report(cmp, "deleted")
+	restored, err := h.repo.Restore(r.Context(), company.Lookup{ID: pathID(p), Version: version})
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to report event.")
+		newDomainError(r, "Company restoration failed.", err).Write(w)
+		return
 	}
-	apiResponse{Code: http.StatusOK, Body: ""}.Write(w)
+	w.Header().Set("ETag", etag(restored))
+	apiResponse{Code: http.StatusOK, Body: restored}.Write(w)
 }
 
-func (h *Handler) updateCompany(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	uid, err := uuid.Parse(id)
+type collaborators struct {
+	Collaborators []uuid.UUID `json:"collaborators"`
+}
 
-	var claims auth.APIClaims
-	err = h.pk.Verify(auth.Token(r), &claims)
+// setCollaborators replaces the users who can change the company besides its owner.
+func (h *Handler) setCollaborators(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	version, err := ifMatch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		newAPIError(r, http.StatusPreconditionFailed, "Precondition failed.", err).Write(w)
 		return
 	}
-
-	var cmp company.Company
-	err = json.NewDecoder(io.LimitReader(r.Body, bodySizeLimit)).Decode(&cmp)
+	var body collaborators
+	err = json.NewDecoder(io.LimitReader(r.Body, collaboratorsSizeLimit)).Decode(&body)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Invalid company data.")
-		newAPIError(http.StatusBadRequest, "Invalid company data", err).Write(w)
+		newAPIError(r, http.StatusBadRequest, "Invalid collaborators.", err).Write(w)
 		return
 	}
-	cmp.ID = uid
-	err = cmp.Validate()
+	if body.Collaborators == nil {
+		body.Collaborators = []uuid.UUID{}
+	}
+	err = company.ValidateCollaborators(body.Collaborators)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Invalid company data.")
-		newAPIError(http.StatusBadRequest, "Invalid company data", err).Write(w)
+		newDomainError(r, "Invalid collaborators.", err).Write(w)
 		return
 	}
-	created, err := h.repo.UpdateOne(r.Context(), company.Lookup{ID: uid}, cmp)
+	updated, err := h.repo.SetCollaborators(r.Context(), company.Lookup{ID: pathID(p), Version: version}, body.Collaborators)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Company creation failed.")
-		newAPIError(http.StatusBadRequest, "Company creation failed.", err).Write(w)
+		newDomainError(r, "Collaborators update failed.", err).Write(w)
+		return
+	}
+	w.Header().Set("ETag", etag(updated))
+	apiResponse{Code: http.StatusOK, Body: updated}.Write(w)
+}
+
+type historyPage struct {
+	Data   []company.Change `json:"data"`
+	Paging paging           `json:"paging"`
+}
+
+// fetchHistory lists changes of a company, or with ?as_of= returns the company as it was at that time.
+func (h *Handler) fetchHistory(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := r.URL.Query()
+	if v := q.Get("as_of"); v != "" {
+		at, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			newAPIError(r, http.StatusBadRequest, "Invalid query.", err).Write(w)
+			return
+		}
+		c, err := h.repo.AsOf(r.Context(), pathID(p), at)
+		if err != nil {
+			newDomainError(r, "Failed to reconstruct company.", err).Write(w)
+			return
+		}
+		apiResponse{Code: http.StatusOK, Body: c}.Write(w)
 		return
 	}
-	err = h.report(cmp, "updated")
+
+	lookup, err := parsePageQuery(q)
 	if err != nil {
-		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to report event.")
+		newAPIError(r, http.StatusBadRequest, "Invalid query.", err).Write(w)
+		return
 	}
-	apiResponse{Code: http.StatusCreated, Body: created}.Write(w)
+	hl := company.HistoryLookup{CompanyID: pathID(p), Limit: lookup.Limit, Offset: lookup.Offset}
+	changes, err := h.repo.History(r.Context(), hl)
+	if err != nil {
+		newDomainError(r, "Failed to fetch company history.", err).Write(w)
+		return
+	}
+	total, err := h.repo.CountHistory(r.Context(), hl)
+	if err != nil {
+		newDomainError(r, "Failed to count company history.", err).Write(w)
+		return
+	}
+	if total == 0 {
+		newDomainError(r, "Failed to fetch company history.", company.ErrNotFound).Write(w)
+		return
+	}
+
+	page := historyPage{
+		Data: changes,
+		Paging: paging{
+			Total:  total,
+			Limit:  hl.Limit,
+			Offset: hl.Offset,
+		},
+	}
+	if page.Data == nil {
+		page.Data = []company.Change{}
+	}
+	if next := hl.Offset + len(changes); int64(next) < total {
+		page.Paging.NextOffset = &next
+	}
+	apiResponse{Code: http.StatusOK, Body: page}.Write(w)
 }
 
-func (h *Handler) report(event company.Company, ev string) error {
-	cmpEvent, err := json.Marshal(event)
+// includeDeleted tells whether soft deleted companies are requested, only admins can see them.
+func (h *Handler) includeDeleted(r *http.Request) (bool, error) {
+	include, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+	if !include {
+		return false, nil
+	}
+	_, err := h.authorize(r, auth.ScopeCompaniesAdmin)
 	if err != nil {
-		return err
+		return false, err
 	}
-	err = h.eventProducer.Produce(ev + string(cmpEvent))
+	return true, nil
+}
+
+// authorize verifies the request token grants the scopes, makes its user the actor of company changes
+// and its tenant the tenant of the request.
+func (h *Handler) authorize(r *http.Request, scopes ...string) (*http.Request, error) {
+	claims, err := h.authz.Authorize(r, scopes...)
 	if err != nil {
-		return err
+		return r, err
+	}
+	ctx := auth.WithClaims(r.Context(), claims)
+	ctx = company.WithActor(ctx, company.Actor{
+		UserID: claims.UserID,
+		Admin:  claims.Granted()[auth.ScopeCompaniesAdmin],
+	})
+	ctx = company.WithTenant(ctx, claims.TenantID)
+	return r.WithContext(ctx), nil
+}
+
+// authError responds 401 to missing or invalid tokens and 403 to tokens lacking the scopes.
+func authError(w http.ResponseWriter, r *http.Request, err error, scopes []string) {
+	if !errors.Is(err, auth.ErrForbidden) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		newAPIError(r, http.StatusUnauthorized, "Authentication required.", err).Write(w)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+	newAPIError(r, http.StatusForbidden, "Permission denied.", err).Write(w)
+}
+
+// identified responds 404 to routes whose :id is not a valid ID, so that handlers never look up uuid.Nil.
+func identified(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		_, err := uuid.Parse(p.ByName("id"))
+		if err != nil {
+			newAPIError(r, http.StatusNotFound, "Not found.", err).Write(w)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// pathID returns the ID from the path of an identified route.
+func pathID(p httprouter.Params) uuid.UUID {
+	uid, _ := uuid.Parse(p.ByName("id"))
+	return uid
+}
+
+func mediaType(r *http.Request) string {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt
+}
+
+// problem is an RFC 7807 problem details body.
+type problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Errors   []company.Violation `json:"errors,omitempty"`
+}
+
+func newAPIError(r *http.Request, code int, msg string, err error) apiResponse {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   strings.TrimSuffix(msg, ".") + ": " + err.Error(),
+		Instance: r.URL.Path,
 	}
-	return nil
+	var verr *company.ValidationError
+	if errors.As(err, &verr) {
+		p.Errors = verr.Violations
+	}
+	return apiResponse{Code: code, Body: p, ContentType: "application/problem+json"}
 }
 
-type errBody struct {
-	Msg   string `json:"msg"`
-	Error string `json:"error"`
+// newDomainError responds to a failed company operation with the status matching the error kind.
+// Failures of the server itself are logged, errors of the client are not.
+func newDomainError(r *http.Request, msg string, err error) apiResponse {
+	code := statusOf(err)
+	if code >= http.StatusInternalServerError {
+		log.Ctx(r.Context()).Error().Err(err).Msg(msg)
+	}
+	return newAPIError(r, code, msg, err)
 }
 
-func newAPIError(code int, msg string, err error) apiResponse {
-	return apiResponse{Code: code, Body: errBody{Msg: msg, Error: err.Error()}}
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, company.ErrNotFound), errors.Is(err, webhook.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, company.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, company.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, company.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, company.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, company.ErrUnavailable), errors.Is(err, webhook.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 // apiResponse is used as a convenient wrapper to send responses.
 type apiResponse struct {
 	Code int
 	Body interface{}
+	// ContentType defaults to application/json.
+	ContentType string
 }
 
 func (r apiResponse) Write(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
+	ct := r.ContentType
+	if ct == "" {
+		ct = "application/json"
+	}
+	w.Header().Set("Content-Type", ct)
 	w.WriteHeader(r.Code)
 	_ = json.NewEncoder(w).Encode(r.Body)
 }
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/auth"
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo serves companies from memory, methods which are not overridden panic.
+type fakeRepo struct {
+	Repo
+	companies []company.Company
+}
+
+func (f *fakeRepo) Fetch(_ context.Context, lookup company.Lookup) ([]company.Company, error) {
+	var fetched []company.Company
+	for _, c := range f.companies {
+		if c.DeletedAt == nil || lookup.IncludeDeleted {
+			fetched = append(fetched, c)
+		}
+	}
+	return fetched, nil
+}
+
+func (f *fakeRepo) Count(ctx context.Context, lookup company.Lookup) (int64, error) {
+	fetched, err := f.Fetch(ctx, lookup)
+	return int64(len(fetched)), err
+}
+
+func (f *fakeRepo) FetchOne(_ context.Context, lookup company.Lookup) (company.Company, error) {
+	for _, c := range f.companies {
+		if c.ID == lookup.ID && (c.DeletedAt == nil || lookup.IncludeDeleted) {
+			return c, nil
+		}
+	}
+	return company.Company{}, company.ErrNotFound
+}
+
+func (f *fakeRepo) DeleteOne(ctx context.Context, lookup company.Lookup) (company.Company, error) {
+	c, err := f.FetchOne(ctx, company.Lookup{ID: lookup.ID})
+	if err != nil {
+		return company.Company{}, err
+	}
+	if lookup.Version != 0 && lookup.Version != c.Version {
+		return company.Company{}, company.ErrVersionMismatch
+	}
+	return c, nil
+}
+
+// racingRepo fails updates as if the company changed since it was fetched.
+type racingRepo struct {
+	fakeRepo
+	updated []company.Lookup
+}
+
+func (f *racingRepo) UpdateOne(_ context.Context, lookup company.Lookup, _ company.Patch) (company.Company, error) {
+	f.updated = append(f.updated, lookup)
+	return company.Company{}, company.ErrVersionMismatch
+}
+
+// testServer serves the handler of the repo and returns a function sending requests with a token of the scopes.
+func testServer(t *testing.T, repo Repo) func(method, path, body string, header http.Header, scopes ...string) *http.Response {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	sk, err := auth.NewSecretKey("", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	pk, err := auth.NewPublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	require.NoError(t, err)
+
+	h := NewHandler(repo, nil, pk, company.NewCursorCodec("secret", time.Hour), event.Nop{})
+	return func(method, path, body string, header http.Header, scopes ...string) *http.Response {
+		token, err := sk.Sign(auth.NewAPIClaims("test", uuid.New(), scopes...))
+		require.NoError(t, err)
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+}
+
+func TestHandler_InvalidID(t *testing.T) {
+	t.Parallel()
+
+	do := testServer(t, &fakeRepo{})
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/v1/companies/not-a-uuid", ""},
+		{http.MethodPatch, "/v1/companies/not-a-uuid", `{"name":"Acme"}`},
+		{http.MethodDelete, "/v1/companies/not-a-uuid", ""},
+		{http.MethodPut, "/v1/companies/not-a-uuid/collaborators", `[]`},
+		{http.MethodPost, "/v1/companies/not-a-uuid/restore", ""},
+	} {
+		tc := tc
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			t.Parallel()
+
+			resp := do(tc.method, tc.path, tc.body, nil, auth.ScopeCompaniesAdmin)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, string(body))
+		})
+	}
+}
+
+func TestHandler_IncludeDeleted(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	do := testServer(t, &fakeRepo{companies: []company.Company{{ID: id}}})
+
+	resp := do(http.MethodGet, "/v1/companies/"+id.String()+"?include_deleted=true", "", nil, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), auth.ScopeCompaniesAdmin)
+
+	resp = do(http.MethodGet, "/v1/companies/"+id.String()+"?include_deleted=true", "", nil, auth.ScopeCompaniesAdmin)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_FetchCompaniesValidators(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	deleted := modified.Add(time.Hour)
+	repo := &fakeRepo{companies: []company.Company{
+		{ID: uuid.New(), Name: "Acme", Version: 1, UpdatedAt: modified},
+		{ID: uuid.New(), Name: "Bolt", Version: 2, UpdatedAt: modified},
+	}}
+	do := testServer(t, repo)
+
+	resp := do(http.MethodGet, "/v1/companies", "", nil, auth.ScopeCompaniesRead)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, tag)
+	assert.Empty(t, resp.Header.Get("Last-Modified"))
+
+	// Deleting a company leaves the remaining ones as old as they were, the list has changed nonetheless.
+	repo.companies[1].DeletedAt = &deleted
+	header := http.Header{
+		"If-None-Match":     {tag},
+		"If-Modified-Since": {modified.Format(http.TimeFormat)},
+	}
+	resp = do(http.MethodGet, "/v1/companies", "", header, auth.ScopeCompaniesRead)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(http.MethodGet, "/v1/companies", "", http.Header{"If-Modified-Since": header["If-Modified-Since"]}, auth.ScopeCompaniesRead)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHandler_ReadScope(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	do := testServer(t, &fakeRepo{companies: []company.Company{{ID: id}}})
+	for _, path := range []string{"/v1/companies", "/v1/companies/" + id.String()} {
+		resp := do(http.MethodGet, path, "", nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, path)
+
+		resp = do(http.MethodGet, path, "", nil, auth.ScopeCompaniesRead)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+}
+
+func TestHandler_IfMatch(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	do := testServer(t, &fakeRepo{companies: []company.Company{{ID: id, Name: "Acme", Version: 2}}})
+	path := "/v1/companies/" + id.String()
+
+	for name, header := range map[string]string{
+		"Stale version":  `"1"`,
+		"Weak tag":       `W/"2"`,
+		"Malformed tag":  `2`,
+		"Invalid number": `"0"`,
+	} {
+		resp := do(http.MethodPatch, path, `{"name":"Bolt"}`, http.Header{"If-Match": {header}}, auth.ScopeCompaniesWrite)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, name)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"), name)
+	}
+
+	resp := do(http.MethodDelete, path, "", http.Header{"If-Match": {`"1"`}}, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = do(http.MethodDelete, path, "", http.Header{"If-Match": {`"2"`}}, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, path, "", nil, auth.ScopeCompaniesRead)
+	defer resp.Body.Close()
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+}
+
+func TestHandler_ConcurrentUpdate(t *testing.T) {
+	t.Parallel()
+
+	employees, registered, typ := 10, true, company.Types[0]
+	id := uuid.New()
+	repo := &racingRepo{fakeRepo: fakeRepo{companies: []company.Company{{
+		ID:           id,
+		Name:         "Acme",
+		EmployeesNum: &employees,
+		Registered:   &registered,
+		Type:         &typ,
+		Version:      2,
+	}}}}
+	do := testServer(t, repo)
+	path := "/v1/companies/" + id.String()
+
+	resp := do(http.MethodPatch, path, `{"name":"Bolt"}`, nil, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	resp = do(http.MethodPatch, path, `{"name":"Bolt"}`, http.Header{"If-Match": {`"2"`}}, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	// The update applies only to the fetched version, with or without If-Match.
+	require.Len(t, repo.updated, 2)
+	for _, lookup := range repo.updated {
+		assert.Equal(t, company.Lookup{ID: id, Version: 2}, lookup)
+	}
+}
+
+func TestHandler_Problems(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	do := testServer(t, &fakeRepo{companies: []company.Company{{ID: id, Name: "Acme", Version: 1}}})
+
+	t.Run("Unsupported patch format", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(http.MethodPatch, "/v1/companies/"+id.String(), `name=Bolt`, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, auth.ScopeCompaniesWrite)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("Validation errors", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(http.MethodPost, "/v1/companies", `{"name":"A name longer than 15","type":"Unknown"}`, nil, auth.ScopeCompaniesWrite)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+		var p problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+		assert.Equal(t, "about:blank", p.Type)
+		assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+		assert.Equal(t, "/v1/companies", p.Instance)
+		assert.ElementsMatch(t, []company.Violation{
+			{Field: "name", Code: company.CodeTooLong, Message: "name cannot be more than 15 characters"},
+			{Field: "employees_num", Code: company.CodeRequired, Message: "employees_num cannot be empty"},
+			{Field: "registered", Code: company.CodeRequired, Message: "registered cannot be empty"},
+			{Field: "type", Code: company.CodeUnknown, Message: "unknown company type"},
+		}, p.Errors)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(http.MethodGet, "/v1/companies", "", http.Header{"Authorization": {"Bearer invalid"}}, auth.ScopeCompaniesRead)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("Recovered server error", func(t *testing.T) {
+		t.Parallel()
+
+		// The fake does not implement History, calling it panics.
+		resp := do(http.MethodGet, "/v1/companies/"+id.String()+"/history", "", nil, auth.ScopeCompaniesRead)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+		var p problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+		assert.Equal(t, http.StatusInternalServerError, p.Status)
+		assert.NotContains(t, p.Detail, "nil pointer")
+	})
+
+	t.Run("Unknown route", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(http.MethodGet, "/v2/companies", "", nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+		resp = do(http.MethodPut, "/v1/companies", "", nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("No errors of other problems", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(http.MethodGet, "/v1/companies/"+uuid.NewString(), "", nil, auth.ScopeCompaniesRead)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.NotContains(t, body, "errors")
+	})
+}
+
+func TestHandler_DebugVars(t *testing.T) {
+	t.Parallel()
+
+	do := testServer(t, &fakeRepo{})
+	resp := do(http.MethodGet, "/debug/vars", "", nil, auth.ScopeCompaniesWrite)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/debug/vars", "", nil, auth.ScopeCompaniesAdmin)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var vars map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&vars))
+	assert.Contains(t, vars, "cmdline")
+}
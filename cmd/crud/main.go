@@ -14,6 +14,7 @@ import (
 	"github.com/a-romancev/crud_task/auth"
 	"github.com/a-romancev/crud_task/company"
 	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/a-romancev/crud_task/webhook"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -41,9 +42,16 @@ func main() {
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	pk, err := auth.NewPublicKey(conf.PublicKey)
+	source, err := keySource(conf)
 	if err != nil {
-		log.Fatal().Msg("Failed to parse public key.")
+		log.Fatal().Err(err).Msg("Failed to parse public key.")
+	}
+	keys, err := auth.NewKeySet(ctx, source)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load keys.")
+	}
+	if conf.Keys.Dir != "" || conf.Keys.JWKSURL != "" {
+		go keys.Run(ctx, conf.Keys.Refresh)
 	}
 
 	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(fmt.Sprintf(
@@ -58,15 +66,28 @@ func main() {
 	}
 	mongoDB := mongoClient.Database("companies")
 	companyMongo := company.NewMongo(mongoDB)
+	if conf.Mongo.Retention > 0 {
+		go purge(ctx, companyMongo, conf.Mongo.Retention)
+	}
 
-	producer := event.NewProducer(event.KafkaConf{
-		Topic:   conf.Kafka.Topic,
-		Servers: conf.Kafka.Servers,
+	sink, err := event.NewSink(event.Conf{
+		Sink:  conf.Event.Sink,
+		Kafka: conf.Kafka,
+		File:  conf.Event.File,
 	})
+	if err != nil {
+		log.Ctx(ctx).Fatal().Err(err).Msg("Failed to create event sink.")
+	}
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		event.NewRelay(event.NewOutbox(mongoDB), sink).Run(ctx)
+	}()
 
+	cursors := company.NewCursorCodec(conf.Cursor.Secret, conf.Cursor.TTL)
 	webServer := &http.Server{
 		Addr:    conf.ListenWebAddress,
-		Handler: NewHandler(companyMongo, producer, pk),
+		Handler: NewHandler(company.NewService(companyMongo), webhook.NewMongo(mongoDB), keys, cursors, event.OverloaderOf(sink)),
 		BaseContext: func(net.Listener) context.Context {
 			return ctx
 		},
@@ -88,5 +109,62 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	_ = webServer.Shutdown(shutdownCtx)
+	<-relayDone
+	err = sink.Close()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to close event sink.")
+	}
 	log.Ctx(ctx).Info().Msg("Shutdown complete.")
 }
+
+// jwksTimeout bounds fetching the JWKS.
+const jwksTimeout = 10 * time.Second
+
+// keySource merges the configured keys, the public key is the one of tokens without the kid header.
+func keySource(conf Config) (auth.KeySource, error) {
+	static := make(map[string]*auth.PublicKey)
+	if conf.PublicKey != "" {
+		pk, err := auth.NewPublicKeyAlg(conf.PublicKeyAlg, conf.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		static[""] = pk
+	}
+	for _, k := range conf.Keys.Static {
+		pk, err := auth.NewPublicKeyAlg(k.Algorithm, k.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", k.ID, err)
+		}
+		static[k.ID] = pk
+	}
+	sources := []auth.KeySource{auth.StaticKeys(static)}
+	if conf.Keys.Dir != "" {
+		sources = append(sources, auth.DirKeys(conf.Keys.Dir))
+	}
+	if conf.Keys.JWKSURL != "" {
+		sources = append(sources, auth.JWKSKeys(&http.Client{Timeout: jwksTimeout}, conf.Keys.JWKSURL))
+	}
+	return auth.MergeKeys(sources...), nil
+}
+
+const purgeInterval = time.Hour
+
+// purge periodically removes companies soft deleted longer than the retention ago.
+func purge(ctx context.Context, companies *company.Mongo, retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := companies.PurgeAllTenants(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to purge deleted companies.")
+		} else if n > 0 {
+			log.Ctx(ctx).Info().Int64("count", n).Msg("Purged deleted companies.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
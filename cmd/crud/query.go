@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ownerMe filters companies owned by the user of the request token, e.g. "?owner=me".
+const ownerMe = "me"
+
+// parsePageQuery reads offset based paging only.
+func parsePageQuery(q url.Values) (company.Lookup, error) {
+	lookup := company.Lookup{
+		Limit: defaultPageSize,
+	}
+
+	var err error
+	if v := q.Get("limit"); v != "" {
+		lookup.Limit, err = strconv.Atoi(v)
+		if err != nil || lookup.Limit < 1 || lookup.Limit > maxPageSize {
+			return company.Lookup{}, errors.Errorf("limit should be a number between 1 and %d", maxPageSize)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		lookup.Offset, err = strconv.Atoi(v)
+		if err != nil || lookup.Offset < 0 {
+			return company.Lookup{}, errors.New("offset should be a non-negative number")
+		}
+	}
+	return lookup, nil
+}
+
+// parseListQuery reads paging, sorting and filters of the company listing.
+// Sort is a field name optionally prefixed with "-" for descending order, e.g. "?sort=-employees_num".
+// A cursor carries its own sort order, so the sort parameter may be omitted when paging with it.
+func parseListQuery(q url.Values, cursors *company.CursorCodec) (company.Lookup, error) {
+	lookup, err := parsePageQuery(q)
+	if err != nil {
+		return company.Lookup{}, err
+	}
+	lookup.Query = strings.TrimSpace(q.Get("q"))
+	if lookup.Query != "" {
+		lookup.Sort = company.Sort{Field: company.SortRelevance}
+	}
+	if v := q.Get("sort"); v != "" {
+		lookup.Sort = company.Sort{
+			Field: strings.TrimPrefix(v, "-"),
+			Desc:  strings.HasPrefix(v, "-"),
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if q.Get("offset") != "" {
+			return company.Lookup{}, errors.New("cursor and offset cannot be used together")
+		}
+		cur, err := cursors.Decode(v)
+		if err != nil {
+			return company.Lookup{}, err
+		}
+		if q.Get("sort") == "" {
+			lookup.Sort = cur.Sort
+		}
+		lookup.After = &cur
+	}
+
+	if v := q.Get("type"); v != "" {
+		lookup.Type = &v
+	}
+	if v := q.Get("registered"); v != "" {
+		registered, err := strconv.ParseBool(v)
+		if err != nil {
+			return company.Lookup{}, errors.New("registered should be a boolean")
+		}
+		lookup.Registered = &registered
+	}
+	if v := q.Get("employees_num_min"); v != "" {
+		min, err := strconv.Atoi(v)
+		if err != nil {
+			return company.Lookup{}, errors.New("employees_num_min should be a number")
+		}
+		lookup.EmployeesMin = &min
+	}
+	if v := q.Get("employees_num_max"); v != "" {
+		max, err := strconv.Atoi(v)
+		if err != nil {
+			return company.Lookup{}, errors.New("employees_num_max should be a number")
+		}
+		lookup.EmployeesMax = &max
+	}
+	if v := q.Get("owner"); v != "" && v != ownerMe {
+		owner, err := uuid.Parse(v)
+		if err != nil {
+			return company.Lookup{}, errors.New(`owner should be "me" or a user ID`)
+		}
+		lookup.OwnerID = owner
+	}
+
+	return lookup, lookup.Validate()
+}
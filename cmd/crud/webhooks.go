@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/a-romancev/crud_task/webhook"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+type WebhookRepo interface {
+	Create(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error)
+	Fetch(ctx context.Context, lookup webhook.Lookup) ([]webhook.Subscription, error)
+	Count(ctx context.Context, lookup webhook.Lookup) (int64, error)
+	FetchOne(ctx context.Context, id uuid.UUID) (webhook.Subscription, error)
+	Update(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Deliveries(ctx context.Context, lookup webhook.Lookup) ([]webhook.Delivery, error)
+	CountDeliveries(ctx context.Context, lookup webhook.Lookup) (int64, error)
+}
+
+type webhooksPage struct {
+	Data   []webhook.Subscription `json:"data"`
+	Paging paging                 `json:"paging"`
+}
+
+type deliveriesPage struct {
+	Data   []webhook.Delivery `json:"data"`
+	Paging paging             `json:"paging"`
+}
+
+func (h *Handler) fetchWebhooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid query.", err).Write(w)
+		return
+	}
+	lookup := webhook.Lookup{Limit: q.Limit, Offset: q.Offset}
+	subs, err := h.webhooks.Fetch(r.Context(), lookup)
+	if err != nil {
+		newDomainError(r, "Failed to fetch webhooks.", err).Write(w)
+		return
+	}
+	total, err := h.webhooks.Count(r.Context(), lookup)
+	if err != nil {
+		newDomainError(r, "Failed to count webhooks.", err).Write(w)
+		return
+	}
+
+	page := webhooksPage{
+		Data: make([]webhook.Subscription, 0, len(subs)),
+		Paging: paging{
+			Total:  total,
+			Limit:  lookup.Limit,
+			Offset: lookup.Offset,
+		},
+	}
+	for _, s := range subs {
+		page.Data = append(page.Data, s.Redacted())
+	}
+	if next := lookup.Offset + len(subs); int64(next) < total {
+		page.Paging.NextOffset = &next
+	}
+	apiResponse{Code: http.StatusOK, Body: page}.Write(w)
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var s webhook.Subscription
+	err := json.NewDecoder(io.LimitReader(r.Body, bodySizeLimit)).Decode(&s)
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid webhook data.", err).Write(w)
+		return
+	}
+	s.ID = uuid.New()
+	err = s.Validate()
+	if err != nil {
+		newDomainError(r, "Invalid webhook data.", err).Write(w)
+		return
+	}
+	created, err := h.webhooks.Create(r.Context(), s)
+	if err != nil {
+		newDomainError(r, "Webhook creation failed.", err).Write(w)
+		return
+	}
+	apiResponse{Code: http.StatusCreated, Body: created.Redacted()}.Write(w)
+}
+
+func (h *Handler) fetchWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	s, err := h.webhooks.FetchOne(r.Context(), pathID(p))
+	if err != nil {
+		newDomainError(r, "Failed to fetch webhook.", err).Write(w)
+		return
+	}
+	apiResponse{Code: http.StatusOK, Body: s.Redacted()}.Write(w)
+}
+
+func (h *Handler) updateWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var patch webhook.Patch
+	err := json.NewDecoder(io.LimitReader(r.Body, bodySizeLimit)).Decode(&patch)
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid webhook data.", err).Write(w)
+		return
+	}
+	current, err := h.webhooks.FetchOne(r.Context(), pathID(p))
+	if err != nil {
+		newDomainError(r, "Failed to fetch webhook.", err).Write(w)
+		return
+	}
+	s := patch.Apply(current)
+	err = s.Validate()
+	if err != nil {
+		newDomainError(r, "Invalid webhook data.", err).Write(w)
+		return
+	}
+	updated, err := h.webhooks.Update(r.Context(), s)
+	if err != nil {
+		newDomainError(r, "Webhook update failed.", err).Write(w)
+		return
+	}
+	apiResponse{Code: http.StatusOK, Body: updated.Redacted()}.Write(w)
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := h.webhooks.Delete(r.Context(), pathID(p))
+	if err != nil {
+		newDomainError(r, "Webhook deletion failed.", err).Write(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fetchDeliveries lists deliveries of a webhook with all their attempts, newest first.
+func (h *Handler) fetchDeliveries(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		newAPIError(r, http.StatusBadRequest, "Invalid query.", err).Write(w)
+		return
+	}
+	_, err = h.webhooks.FetchOne(r.Context(), pathID(p))
+	if err != nil {
+		newDomainError(r, "Failed to fetch webhook.", err).Write(w)
+		return
+	}
+	lookup := webhook.Lookup{ID: pathID(p), Limit: q.Limit, Offset: q.Offset}
+	deliveries, err := h.webhooks.Deliveries(r.Context(), lookup)
+	if err != nil {
+		newDomainError(r, "Failed to fetch webhook deliveries.", err).Write(w)
+		return
+	}
+	total, err := h.webhooks.CountDeliveries(r.Context(), lookup)
+	if err != nil {
+		newDomainError(r, "Failed to count webhook deliveries.", err).Write(w)
+		return
+	}
+
+	page := deliveriesPage{
+		Data: deliveries,
+		Paging: paging{
+			Total:  total,
+			Limit:  lookup.Limit,
+			Offset: lookup.Offset,
+		},
+	}
+	if page.Data == nil {
+		page.Data = []webhook.Delivery{}
+	}
+	if next := lookup.Offset + len(deliveries); int64(next) < total {
+		page.Paging.NextOffset = &next
+	}
+	apiResponse{Code: http.StatusOK, Body: page}.Write(w)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// projector consumes company events and maintains the read model of companies.
+func main() {
+	var confPath string
+	flag.StringVar(&confPath, "conf", ".", "PATH to config folder")
+	flag.Parse()
+
+	var conf Config
+	conf = conf.WithFile(confPath)
+	err := conf.Validate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Config validation error.")
+	}
+
+	level, err := zerolog.ParseLevel(conf.LogLevel)
+	if err != nil {
+		log.Fatal().Msgf("Unknown log level -- %s.", conf.LogLevel)
+	}
+	ctx := context.Background()
+	ctx = log.Logger.Level(level).WithContext(ctx)
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(fmt.Sprintf(
+		"mongodb://%s:%s@%s/%s",
+		conf.Mongo.User,
+		conf.Mongo.Password,
+		conf.Mongo.Host,
+		conf.Mongo.Database,
+	)))
+	if err != nil {
+		log.Ctx(ctx).Fatal().Err(err).Msg("Failed to connect to mongo.")
+	}
+	projection := company.NewProjection(mongoClient.Database(conf.Mongo.Database))
+
+	consumer, err := event.NewConsumer(conf.Kafka, conf.Consumer, projection)
+	if err != nil {
+		log.Ctx(ctx).Fatal().Err(err).Msg("Failed to create consumer.")
+	}
+
+	log.Ctx(ctx).Info().Str("topic", conf.Kafka.Topic).Str("group", conf.Consumer.Group).Msg("Consuming events.")
+	runErr := consumer.Run(ctx)
+
+	log.Ctx(ctx).Info().Msg("Shutting down.")
+	err = consumer.Close()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to close consumer.")
+	}
+	_ = mongoClient.Disconnect(context.Background())
+	if runErr != nil {
+		log.Ctx(ctx).Fatal().Err(runErr).Msg("Consumer failed.")
+	}
+	log.Ctx(ctx).Info().Msg("Shutdown complete.")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// replay republishes company events, so that downstream consumers can rebuild their state.
+// It publishes either a snapshot of every company of a tenant or the recorded history of changes.
+func main() {
+	var (
+		confPath, tenant, sourceName, typ, from, to, checkpointPath string
+		rate                                                        float64
+		dryRun                                                      bool
+	)
+	flag.StringVar(&confPath, "conf", ".", "PATH to config folder")
+	flag.StringVar(&tenant, "tenant", "", "tenant to replay, required")
+	flag.StringVar(&sourceName, "source", SourceCompanies, "replay snapshots of companies or their history")
+	flag.StringVar(&typ, "type", "", "replay only companies of the type")
+	flag.StringVar(&from, "from", "", "first company ID to replay")
+	flag.StringVar(&to, "to", "", "company ID following the last one to replay")
+	flag.Float64Var(&rate, "rate", 100, "events per second, 0 means no limit")
+	flag.BoolVar(&dryRun, "dry-run", false, "print events to stdout instead of publishing them, the checkpoint is ignored")
+	flag.StringVar(&checkpointPath, "checkpoint", "replay.checkpoint", "file to resume an interrupted replay from")
+	flag.Parse()
+
+	var conf Config
+	conf = conf.WithFile(confPath)
+	err := conf.Validate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Config validation error.")
+	}
+	if tenant == "" {
+		log.Fatal().Msg("Tenant not set.")
+	}
+	lookup, err := scanLookup(typ, from, to)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid filter.")
+	}
+	if rate < 0 {
+		log.Fatal().Msg("Rate cannot be negative.")
+	}
+
+	level, err := zerolog.ParseLevel(conf.LogLevel)
+	if err != nil {
+		log.Fatal().Msgf("Unknown log level -- %s.", conf.LogLevel)
+	}
+	ctx := context.Background()
+	ctx = log.Logger.Level(level).WithContext(ctx)
+	ctx = company.WithTenant(ctx, tenant)
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(fmt.Sprintf(
+		"mongodb://%s:%s@%s/%s",
+		conf.Mongo.User,
+		conf.Mongo.Password,
+		conf.Mongo.Host,
+		conf.Mongo.Database,
+	)))
+	if err != nil {
+		log.Ctx(ctx).Fatal().Err(err).Msg("Failed to connect to mongo.")
+	}
+	companyMongo := company.NewMongo(mongoClient.Database(conf.Mongo.Database))
+
+	r := replayer{
+		name:           sourceName,
+		tenant:         tenant,
+		lookup:         lookup,
+		checkpointPath: checkpointPath,
+	}
+	switch sourceName {
+	case SourceCompanies:
+		r.source = companies(companyMongo)
+	case SourceHistory:
+		r.source = history(companyMongo)
+	default:
+		log.Ctx(ctx).Fatal().Msgf("Unknown source -- %s.", sourceName)
+	}
+	if rate > 0 {
+		r.interval = time.Duration(float64(time.Second) / rate)
+	}
+	if dryRun {
+		r.sink = event.NewWriter(os.Stdout)
+		r.checkpointPath = ""
+	} else {
+		r.sink, err = event.NewProducer(conf.Kafka)
+		if err != nil {
+			log.Ctx(ctx).Fatal().Err(err).Msg("Failed to create producer.")
+		}
+	}
+
+	log.Ctx(ctx).Info().Str("tenant", tenant).Str("source", sourceName).Bool("dry_run", dryRun).Msg("Replaying events.")
+	published, runErr := r.run(ctx)
+	err = r.sink.Close()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to close sink.")
+	}
+	_ = mongoClient.Disconnect(context.Background())
+	if errors.Is(runErr, context.Canceled) {
+		log.Ctx(ctx).Info().Int64("published", published).Msg("Replay interrupted, rerun to resume.")
+		return
+	}
+	if runErr != nil {
+		log.Ctx(ctx).Fatal().Err(runErr).Int64("published", published).Msg("Replay failed, rerun to resume.")
+	}
+	log.Ctx(ctx).Info().Int64("published", published).Msg("Replay complete.")
+}
+
+func scanLookup(typ, from, to string) (company.ScanLookup, error) {
+	var (
+		lookup company.ScanLookup
+		err    error
+	)
+	if typ != "" {
+		found := false
+		for _, t := range company.Types {
+			found = found || t == typ
+		}
+		if !found {
+			return lookup, fmt.Errorf("unknown company type %q", typ)
+		}
+		lookup.Type = &typ
+	}
+	if from != "" {
+		lookup.From, err = uuid.Parse(from)
+		if err != nil {
+			return lookup, fmt.Errorf("invalid from ID: %w", err)
+		}
+	}
+	if to != "" {
+		lookup.To, err = uuid.Parse(to)
+		if err != nil {
+			return lookup, fmt.Errorf("invalid to ID: %w", err)
+		}
+	}
+	return lookup, nil
+}
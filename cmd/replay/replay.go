@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/rs/zerolog/log"
+)
+
+// Sources of replayed events.
+const (
+	SourceCompanies = "companies"
+	SourceHistory   = "history"
+)
+
+const batchSize = 100
+
+type item struct {
+	event event.Event
+	at    company.Checkpoint
+}
+
+// source returns the next batch of events following lookup.After.
+type source func(ctx context.Context, lookup company.ScanLookup) ([]item, error)
+
+// companies publishes a snapshot of every company.
+func companies(m *company.Mongo) source {
+	return func(ctx context.Context, lookup company.ScanLookup) ([]item, error) {
+		found, err := m.Scan(ctx, lookup)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]item, 0, len(found))
+		for _, c := range found {
+			e, err := company.SnapshotOf(c)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item{event: e, at: company.Checkpoint{CompanyID: c.ID}})
+		}
+		return items, nil
+	}
+}
+
+// history publishes the recorded changes again with their original event IDs,
+// so that consumers deduplicating events skip the ones they have seen.
+func history(m *company.Mongo) source {
+	return func(ctx context.Context, lookup company.ScanLookup) ([]item, error) {
+		changes, err := m.ScanHistory(ctx, lookup)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]item, 0, len(changes))
+		for _, c := range changes {
+			e, err := company.EventOf(c)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item{event: e, at: company.Checkpoint{CompanyID: c.CompanyID, Version: c.Version}})
+		}
+		return items, nil
+	}
+}
+
+// checkpoint is stored after every batch, so that an interrupted replay resumes where it stopped.
+type checkpoint struct {
+	Tenant    string             `json:"tenant"`
+	Source    string             `json:"source"`
+	Position  company.Checkpoint `json:"position"`
+	Published int64              `json:"published"`
+}
+
+// replayer publishes events of a source at a limited rate.
+type replayer struct {
+	name   string
+	tenant string
+	source source
+	sink   event.Sink
+	lookup company.ScanLookup
+	// interval between events, zero means no limit.
+	interval time.Duration
+	// checkpointPath is empty when no checkpoint is stored.
+	checkpointPath string
+}
+
+// run replays events until the source is exhausted and returns the number of published events,
+// counting those published before the resumed checkpoint.
+func (r *replayer) run(ctx context.Context) (int64, error) {
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	cp, err := r.load()
+	if err != nil {
+		return 0, err
+	}
+	lookup := r.lookup
+	lookup.Limit = batchSize
+	if cp.Published > 0 {
+		lookup.After = &cp.Position
+		log.Ctx(ctx).Info().Int64("published", cp.Published).Msg("Resuming replay.")
+	}
+
+	for {
+		items, err := r.source(ctx, lookup)
+		if err != nil {
+			return cp.Published, err
+		}
+		if len(items) == 0 {
+			return cp.Published, r.clear()
+		}
+		for _, it := range items {
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return cp.Published, r.stop(cp, ctx.Err())
+				case <-tick:
+				}
+			}
+			err := r.sink.Produce(it.event)
+			if err != nil {
+				return cp.Published, r.stop(cp, fmt.Errorf("failed to publish event %s: %w", it.event.ID, err))
+			}
+			cp.Position = it.at
+			cp.Published++
+			lookup.After = &cp.Position
+		}
+		err = r.save(cp)
+		if err != nil {
+			return cp.Published, err
+		}
+		log.Ctx(ctx).Info().Int64("published", cp.Published).Msg("Replayed batch.")
+	}
+}
+
+// stop saves the checkpoint of an interrupted replay and returns the cause.
+func (r *replayer) stop(cp checkpoint, cause error) error {
+	err := r.save(cp)
+	if err != nil {
+		return fmt.Errorf("%v, failed to save checkpoint: %w", cause, err)
+	}
+	return cause
+}
+
+func (r *replayer) load() (checkpoint, error) {
+	cp := checkpoint{Tenant: r.tenant, Source: r.name}
+	if r.checkpointPath == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(r.checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(b, &cp)
+	if err != nil {
+		return cp, fmt.Errorf("invalid checkpoint %s: %w", r.checkpointPath, err)
+	}
+	if cp.Source != r.name || cp.Tenant != r.tenant {
+		return cp, fmt.Errorf("checkpoint %s belongs to a replay of %s of tenant %s", r.checkpointPath, cp.Source, cp.Tenant)
+	}
+	return cp, nil
+}
+
+// save replaces the checkpoint atomically, so that it is never left half written.
+func (r *replayer) save(cp checkpoint) error {
+	if r.checkpointPath == "" || cp.Published == 0 {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := r.checkpointPath + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.checkpointPath)
+}
+
+// clear removes the checkpoint of a completed replay, so that the next one starts over.
+func (r *replayer) clear() error {
+	if r.checkpointPath == "" {
+		return nil
+	}
+	err := os.Remove(r.checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/a-romancev/crud_task/webhook"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+type Mongo struct {
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Host     string `mapstructure:"host"`
+	Database string `mapstructure:"database"`
+}
+
+type Config struct {
+	LogLevel string             `mapstructure:"loglevel"`
+	Kafka    event.KafkaConf    `mapstructure:"kafka"`
+	Consumer event.ConsumerConf `mapstructure:"consumer"`
+	Worker   webhook.WorkerConf `mapstructure:"worker"`
+	Mongo    Mongo              `mapstructure:"mongo"`
+}
+
+func (c Config) WithFile(confPath string) Config {
+	viper.SetConfigName("webhooks")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(confPath)
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			log.Fatalf("config file not found in path")
+		} else {
+			log.Fatal("error while reading config file")
+		}
+	}
+	if err := viper.Unmarshal(&c); err != nil {
+		log.Fatal("error while reading config file")
+	}
+	return c
+}
+
+func (c Config) Validate() error {
+	if c.LogLevel == "" {
+		return errors.New("loglevel not set")
+	}
+	if c.Mongo.Host == "" {
+		return errors.New("mongoDB host not set")
+	}
+	if c.Mongo.Database == "" {
+		return errors.New("mongoDB database not set")
+	}
+	if c.Mongo.User == "" {
+		return errors.New("mongoDB user not set")
+	}
+	if c.Mongo.Password == "" {
+		return errors.New("mongoDB password not set")
+	}
+	if err := c.Kafka.Validate(); err != nil {
+		return err
+	}
+	if err := c.Consumer.Validate(); err != nil {
+		return err
+	}
+	if err := c.Worker.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -2,16 +2,66 @@ package company
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Errors of the company domain. Specific errors wrap one of the generic kinds,
+// so that callers can tell them apart with errors.Is.
 var (
-	ErrDuplicatedEntry = errors.New("duplicated company")
+	ErrNotFound    = errors.New("company not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("invalid company")
+	ErrUnavailable = errors.New("storage unavailable")
+
+	ErrDuplicatedEntry = fmt.Errorf("duplicated company: %w", ErrConflict)
+	ErrVersionMismatch = errors.New("company version mismatch")
+	ErrNotDeleted      = fmt.Errorf("company is not deleted: %w", ErrConflict)
+	ErrForbidden       = errors.New("not allowed to change the company")
+)
+
+// Violation codes.
+const (
+	CodeRequired = "required"
+	CodeTooLong  = "too_long"
+	CodeUnknown  = "unknown_value"
 )
 
+// Violation is a single invalid field of a company.
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a company.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func (e *ValidationError) add(field, code, msg string) {
+	e.Violations = append(e.Violations, Violation{Field: field, Code: code, Message: msg})
+}
+
 var Types = []string{"Corporations", "NonProfit", "Cooperative", "Sole Proprietorship"}
 
+const maxCollaborators = 100
+
 // In real applications domain objects are not used in API. Separate structs would be generated from openAPI or similar.
 // We keep it simple here.
 type Company struct {
@@ -21,39 +71,191 @@ type Company struct {
 	EmployeesNum *int      `json:"employees_num" bson:"employees_num"`
 	Registered   *bool     `json:"registered" bson:"registered"`
 	Type         *string   `json:"type" bson:"type"`
+	// TenantID is set by the storage from the context, see WithTenant.
+	TenantID string `json:"tenant_id" bson:"tenant_id"`
+	// OwnerID is the user who created the company. Only the owner, collaborators and admins can change it.
+	OwnerID       uuid.UUID   `json:"owner_id" bson:"owner_id"`
+	Collaborators []uuid.UUID `json:"collaborators,omitempty" bson:"collaborators"`
+	// Version is incremented on every update, it starts from 1.
+	Version   int64     `json:"version" bson:"version"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	// DeletedAt is set for soft deleted companies. It is always stored, as null for live ones,
+	// so that the partial unique index on name can tell them apart.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at"`
+
+	// Score is the search relevance, it is only set when companies are searched by a query.
+	Score float64 `json:"score,omitempty" bson:"score,omitempty"`
 }
 
+// Validate returns a *ValidationError with all violations of the company.
 func (c Company) Validate() error {
+	var verr ValidationError
 	if c.ID == uuid.Nil {
-		return errors.New("id should not be empty")
+		verr.add("_id", CodeRequired, "id should not be empty")
 	}
 	if c.Name == "" {
-		return errors.New("name cannot be empty")
+		verr.add("name", CodeRequired, "name cannot be empty")
 	}
 	if c.EmployeesNum == nil {
-		return errors.New("employees_num cannot be empty")
+		verr.add("employees_num", CodeRequired, "employees_num cannot be empty")
 	}
 	if c.Registered == nil {
-		return errors.New("registered cannot be empty")
+		verr.add("registered", CodeRequired, "registered cannot be empty")
 	}
 	if c.Type == nil {
-		return errors.New("type cannot be empty")
+		verr.add("type", CodeRequired, "type cannot be empty")
 	}
 
 	if len(c.Name) > 15 {
-		return errors.New("name cannot be more than 15 characters")
+		verr.add("name", CodeTooLong, "name cannot be more than 15 characters")
 	}
 	if len(c.Description) > 3000 {
-		return errors.New("description cannot be more than 3000 characters")
+		verr.add("description", CodeTooLong, "description cannot be more than 3000 characters")
 	}
-	if !checkIn(*c.Type, Types) {
-		return errors.New("unknown company type")
+	if c.Type != nil && !checkIn(*c.Type, Types) {
+		verr.add("type", CodeUnknown, "unknown company type")
+	}
+	verr.collaborators(c.Collaborators)
+	if len(verr.Violations) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+// ValidateCollaborators returns a *ValidationError when the collaborators of a company are invalid.
+func ValidateCollaborators(ids []uuid.UUID) error {
+	var verr ValidationError
+	verr.collaborators(ids)
+	if len(verr.Violations) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+func (e *ValidationError) collaborators(ids []uuid.UUID) {
+	if len(ids) > maxCollaborators {
+		e.add("collaborators", CodeTooLong, fmt.Sprintf("collaborators cannot be more than %d", maxCollaborators))
+	}
+	for _, id := range ids {
+		if id == uuid.Nil {
+			e.add("collaborators", CodeRequired, "collaborator id should not be empty")
+			return
+		}
+	}
+}
+
+// EditableBy tells whether the user owns the company or collaborates on it.
+func (c Company) EditableBy(user uuid.UUID) bool {
+	if user == uuid.Nil {
+		return false
+	}
+	if c.OwnerID == user {
+		return true
+	}
+	for _, id := range c.Collaborators {
+		if id == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Sortable fields of a company.
+const (
+	SortID           = "_id"
+	SortName         = "name"
+	SortEmployeesNum = "employees_num"
+	// SortRelevance orders search results by their score, most relevant first. It requires a query.
+	SortRelevance = "score"
+)
+
+var SortFields = []string{SortID, SortName, SortEmployeesNum, SortRelevance}
+
+type Sort struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+func (s Sort) Validate() error {
+	if s.Field != "" && !checkIn(s.Field, SortFields) {
+		return errors.New("unknown sort field")
 	}
 	return nil
 }
 
 type Lookup struct {
 	ID uuid.UUID `json:"id"`
+	// Version, when set, makes updates and deletes conditional on the current company version.
+	Version int64 `json:"version"`
+	// IncludeDeleted makes fetches return soft deleted companies too.
+	IncludeDeleted bool `json:"include_deleted"`
+	// Query matches name prefixes case-insensitively and description words by full-text search.
+	Query string `json:"query"`
+
+	Type         *string `json:"type"`
+	Registered   *bool   `json:"registered"`
+	EmployeesMin *int    `json:"employees_min"`
+	EmployeesMax *int    `json:"employees_max"`
+
+	// OwnerID filters companies by owner. Writes only match companies of the owner.
+	OwnerID uuid.UUID `json:"owner_id"`
+	// Editor, when set, makes writes only match companies the user owns or collaborates on.
+	Editor uuid.UUID `json:"-"`
+
+	Sort Sort `json:"sort"`
+	// After continues the listing right after the cursor, it takes precedence over Offset.
+	After *Cursor `json:"-"`
+	// Limit of zero means no limit.
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func (l Lookup) Validate() error {
+	if l.Type != nil && !checkIn(*l.Type, Types) {
+		return errors.New("unknown company type")
+	}
+	if l.EmployeesMin != nil && l.EmployeesMax != nil && *l.EmployeesMin > *l.EmployeesMax {
+		return errors.New("employees_min cannot be greater than employees_max")
+	}
+	if l.Limit < 0 {
+		return errors.New("limit cannot be negative")
+	}
+	if l.Offset < 0 {
+		return errors.New("offset cannot be negative")
+	}
+	if l.Sort.Field == SortRelevance && l.Query == "" {
+		return errors.New("sorting by relevance requires a query")
+	}
+	if l.After != nil && l.After.Sort != l.Sort {
+		return errors.New("cursor was issued for another sort order")
+	}
+	return l.Sort.Validate()
+}
+
+// permits tells whether writes of the lookup may change the company, see OwnerID and Editor.
+func (l Lookup) permits(c Company) bool {
+	if l.OwnerID != uuid.Nil && c.OwnerID != l.OwnerID {
+		return false
+	}
+	return l.Editor == uuid.Nil || c.EditableBy(l.Editor)
+}
+
+// Next returns the cursor of the page following the fetched one.
+func (l Lookup) Next(page []Company) Cursor {
+	cur := CursorAfter(page[len(page)-1], l.Sort)
+	if l.Sort.Field == SortRelevance {
+		// Relevance is computed on the fly and cannot be used as a key, such pages are addressed by position.
+		cur.Offset = l.skip() + len(page)
+	}
+	return cur
+}
+
+func (l Lookup) skip() int {
+	if l.After != nil {
+		return l.After.Offset
+	}
+	return l.Offset
 }
 
 func checkIn(a string, list []string) bool {
@@ -1,13 +1,17 @@
 package company
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/a-romancev/crud_task/internal/platform/mongo/double"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/mongodb"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -31,3 +35,26 @@ func dockerMongo(t *testing.T) *mongo.Database {
 	require.NoError(t, migrator.Up())
 	return db
 }
+
+func TestCompany_Validate(t *testing.T) {
+	t.Parallel()
+
+	unknown := "unknown"
+	err := Company{
+		ID:          uuid.New(),
+		Name:        strings.Repeat("a", 16),
+		Description: strings.Repeat("a", 3001),
+		Type:        &unknown,
+	}.Validate()
+	require.ErrorIs(t, err, ErrValidation)
+
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, []Violation{
+		{Field: "employees_num", Code: CodeRequired, Message: "employees_num cannot be empty"},
+		{Field: "registered", Code: CodeRequired, Message: "registered cannot be empty"},
+		{Field: "name", Code: CodeTooLong, Message: "name cannot be more than 15 characters"},
+		{Field: "description", Code: CodeTooLong, Message: "description cannot be more than 3000 characters"},
+		{Field: "type", Code: CodeUnknown, Message: "unknown company type"},
+	}, verr.Violations)
+}
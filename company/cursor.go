@@ -0,0 +1,154 @@
+package company
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrCursorExpired = errors.New("cursor expired")
+)
+
+// Cursor points right after the last company of a page. Paging with a cursor is keyset based,
+// so pages stay stable when companies are created or deleted in between requests.
+type Cursor struct {
+	Sort Sort
+	// ID is the tie-breaker for equal sort values.
+	ID uuid.UUID
+	// Value of the sort field for the last company. Nil when sorted by ID or when the field is empty.
+	Value interface{}
+	// Offset of the next page, only used for relevance order.
+	Offset int
+}
+
+// CursorAfter returns the cursor pointing right after the company in the given sort order.
+func CursorAfter(c Company, s Sort) Cursor {
+	cur := Cursor{
+		Sort: s,
+		ID:   c.ID,
+	}
+	switch s.Field {
+	case SortName:
+		cur.Value = c.Name
+	case SortEmployeesNum:
+		if c.EmployeesNum != nil {
+			cur.Value = *c.EmployeesNum
+		}
+	}
+	return cur
+}
+
+type cursorToken struct {
+	Field   string          `json:"f,omitempty"`
+	Desc    bool            `json:"d,omitempty"`
+	ID      uuid.UUID       `json:"id"`
+	Value   json.RawMessage `json:"v,omitempty"`
+	Offset  int             `json:"o,omitempty"`
+	Expires int64           `json:"exp"`
+}
+
+// CursorCodec turns cursors into opaque tokens signed with HMAC-SHA256, so that clients
+// can neither read nor forge them.
+type CursorCodec struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+func NewCursorCodec(secret string, ttl time.Duration) *CursorCodec {
+	return &CursorCodec{
+		key: []byte(secret),
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+func (c *CursorCodec) Encode(cur Cursor) (string, error) {
+	value, err := json.Marshal(cur.Value)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(cursorToken{
+		Field:   cur.Sort.Field,
+		Desc:    cur.Sort.Desc,
+		ID:      cur.ID,
+		Value:   value,
+		Offset:  cur.Offset,
+		Expires: c.now().Add(c.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload)), nil
+}
+
+func (c *CursorCodec) Decode(token string) (Cursor, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var t cursorToken
+	err = json.Unmarshal(payload, &t)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if c.now().Unix() > t.Expires {
+		return Cursor{}, ErrCursorExpired
+	}
+	cur := Cursor{
+		Sort:   Sort{Field: t.Field, Desc: t.Desc},
+		ID:     t.ID,
+		Offset: t.Offset,
+	}
+	if cur.Sort.Validate() != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	cur.Value, err = decodeSortValue(cur.Sort.Field, t.Value)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+func (c *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// decodeSortValue restores the Go type of a sort value, JSON alone does not tell an int from a float.
+func decodeSortValue(field string, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	switch field {
+	case SortName:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case SortEmployeesNum:
+		var v int
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	}
+	return nil, errors.New("unexpected cursor value")
+}
@@ -0,0 +1,53 @@
+package company
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := NewCursorCodec("secret", time.Minute)
+	employees := 42
+	c := Company{ID: uuid.New(), Name: "name", EmployeesNum: &employees}
+
+	t.Run("Happy path", func(t *testing.T) {
+		for _, s := range []Sort{{}, {Field: SortName}, {Field: SortEmployeesNum, Desc: true}} {
+			cur := CursorAfter(c, s)
+			token, err := codec.Encode(cur)
+			require.NoError(t, err)
+			decoded, err := codec.Decode(token)
+			require.NoError(t, err)
+			assert.Equal(t, cur, decoded)
+		}
+	})
+
+	t.Run("Tampered token returns error", func(t *testing.T) {
+		token, err := codec.Encode(CursorAfter(c, Sort{Field: SortName}))
+		require.NoError(t, err)
+		parts := strings.Split(token, ".")
+		forged, err := NewCursorCodec("other", time.Minute).Encode(CursorAfter(Company{ID: uuid.New()}, Sort{}))
+		require.NoError(t, err)
+
+		_, err = codec.Decode(strings.Split(forged, ".")[0] + "." + parts[1])
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, err = codec.Decode(parts[0])
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("Expired token returns error", func(t *testing.T) {
+		expired := NewCursorCodec("secret", time.Minute)
+		expired.now = func() time.Time { return time.Now().Add(-time.Hour) }
+		token, err := expired.Encode(CursorAfter(c, Sort{}))
+		require.NoError(t, err)
+
+		_, err = codec.Decode(token)
+		assert.ErrorIs(t, err, ErrCursorExpired)
+	})
+}
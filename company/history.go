@@ -0,0 +1,151 @@
+package company
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+)
+
+// Operations recorded in the company history.
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+)
+
+// Actor is the user on whose behalf companies are changed.
+type Actor struct {
+	UserID uuid.UUID `json:"user_id" bson:"user_id"`
+	// Admin actors can change companies of any owner.
+	Admin bool `json:"admin,omitempty" bson:"admin,omitempty"`
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFrom returns the actor of the context, the zero Actor stands for the system itself.
+func ActorFrom(ctx context.Context) Actor {
+	a, _ := ctx.Value(actorKey{}).(Actor)
+	return a
+}
+
+// Change is an immutable audit record of a single company write.
+type Change struct {
+	ID        uuid.UUID     `json:"id" bson:"_id"`
+	TenantID  string        `json:"tenant_id" bson:"tenant_id"`
+	CompanyID uuid.UUID     `json:"company_id" bson:"company_id"`
+	Version   int64         `json:"version" bson:"version"`
+	Operation string        `json:"operation" bson:"operation"`
+	Actor     Actor         `json:"actor" bson:"actor"`
+	Time      time.Time     `json:"time" bson:"time"`
+	Before    *Company      `json:"before" bson:"before"`
+	After     *Company      `json:"after" bson:"after"`
+	Diff      []FieldChange `json:"diff" bson:"diff"`
+}
+
+// FieldChange is a change of a single field, nil values stand for empty fields.
+type FieldChange struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from" bson:"from"`
+	To    interface{} `json:"to" bson:"to"`
+}
+
+// NewChange records the operation turning before into after, before is nil on creation.
+func NewChange(ctx context.Context, op string, before, after *Company) Change {
+	c := Change{
+		ID:        uuid.New(),
+		Operation: op,
+		Actor:     ActorFrom(ctx),
+		Before:    before,
+		After:     after,
+	}
+	var old, cur map[string]interface{}
+	if before != nil {
+		c.CompanyID, c.TenantID, c.Version, c.Time = before.ID, before.TenantID, before.Version, before.UpdatedAt
+		old = before.fields()
+	}
+	if after != nil {
+		c.CompanyID, c.TenantID, c.Version, c.Time = after.ID, after.TenantID, after.Version, after.UpdatedAt
+		cur = after.fields()
+	}
+	for _, f := range fieldNames {
+		if old[f] != cur[f] {
+			c.Diff = append(c.Diff, FieldChange{Field: f, From: old[f], To: cur[f]})
+		}
+	}
+	if before != nil && after != nil && !sameIDs(before.Collaborators, after.Collaborators) {
+		c.Diff = append(c.Diff, FieldChange{Field: "collaborators", From: before.Collaborators, To: after.Collaborators})
+	}
+	return c
+}
+
+func sameIDs(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// EventSource is the CloudEvents source of company events.
+const EventSource = "/v1/companies"
+
+// Types of company events, the suffix is the version of EventData.
+const (
+	EventCreated  = "company.created.v1"
+	EventUpdated  = "company.updated.v1"
+	EventDeleted  = "company.deleted.v1"
+	EventRestored = "company.restored.v1"
+)
+
+var eventTypes = map[string]string{
+	OpCreate:  EventCreated,
+	OpUpdate:  EventUpdated,
+	OpDelete:  EventDeleted,
+	OpRestore: EventRestored,
+}
+
+// EventData is the data of company events. Created and restored events carry the state after the change,
+// updated events both states and deleted events the last known state.
+type EventData struct {
+	Before *Company `json:"before,omitempty"`
+	After  *Company `json:"after,omitempty"`
+}
+
+// EventOf returns the event published about the change, its ID is the ID of the change.
+func EventOf(c Change) (event.Event, error) {
+	data := EventData{After: c.After}
+	switch c.Operation {
+	case OpUpdate:
+		data.Before = c.Before
+	case OpDelete:
+		data = EventData{Before: c.Before}
+	}
+	e, err := event.New(c.ID.String(), EventSource, eventTypes[c.Operation], c.CompanyID.String(), c.Time, data)
+	if err != nil {
+		return event.Event{}, err
+	}
+	e.SubjectVersion = c.Version
+	e.TenantID = c.TenantID
+	if c.Actor.UserID != uuid.Nil {
+		e.ActorID = c.Actor.UserID.String()
+	}
+	return e, nil
+}
+
+type HistoryLookup struct {
+	CompanyID uuid.UUID `json:"company_id"`
+	// Limit of zero means no limit.
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
@@ -0,0 +1,82 @@
+package company
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChange(t *testing.T) {
+	t.Parallel()
+
+	actor := Actor{UserID: uuid.New()}
+	ctx := WithActor(context.Background(), actor)
+	employees := 10
+	before := Company{
+		ID:           uuid.New(),
+		Name:         "name",
+		EmployeesNum: &employees,
+		Version:      1,
+		UpdatedAt:    time.Now(),
+	}
+	after := before
+	after.Description = "new"
+	after.EmployeesNum = nil
+	after.Version = 2
+	after.UpdatedAt = before.UpdatedAt.Add(time.Second)
+
+	c := NewChange(ctx, OpUpdate, &before, &after)
+	assert.Equal(t, before.ID, c.CompanyID)
+	assert.Equal(t, actor, c.Actor)
+	assert.EqualValues(t, 2, c.Version)
+	assert.Equal(t, after.UpdatedAt, c.Time)
+	assert.Equal(t, []FieldChange{
+		{Field: "description", From: nil, To: "new"},
+		{Field: "employees_num", From: 10, To: nil},
+	}, c.Diff)
+
+	c = NewChange(context.Background(), OpCreate, nil, &before)
+	assert.Equal(t, Actor{}, c.Actor)
+	assert.Len(t, c.Diff, 2)
+
+	shared := after
+	shared.Collaborators = []uuid.UUID{uuid.New()}
+	c = NewChange(ctx, OpUpdate, &after, &shared)
+	assert.Equal(t, []FieldChange{
+		{Field: "collaborators", From: []uuid.UUID(nil), To: shared.Collaborators},
+	}, c.Diff)
+}
+
+func TestEventOf(t *testing.T) {
+	t.Parallel()
+
+	before := Company{ID: uuid.New(), Name: "old", Version: 1}
+	after := before
+	after.Name = "new"
+	after.Version = 2
+
+	c := NewChange(context.Background(), OpUpdate, &before, &after)
+	e, err := EventOf(c)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", e.SpecVersion)
+	assert.Equal(t, c.ID.String(), e.ID)
+	assert.Equal(t, EventUpdated, e.Type)
+	assert.Equal(t, before.ID.String(), e.Subject)
+	assert.EqualValues(t, 2, e.SubjectVersion)
+	assert.Empty(t, e.ActorID)
+	var data EventData
+	require.NoError(t, json.Unmarshal(e.Data, &data))
+	assert.Equal(t, EventData{Before: &before, After: &after}, data)
+
+	e, err = EventOf(NewChange(context.Background(), OpDelete, &after, &Company{ID: after.ID, Version: 3}))
+	require.NoError(t, err)
+	assert.Equal(t, EventDeleted, e.Type)
+	data = EventData{}
+	require.NoError(t, json.Unmarshal(e.Data, &data))
+	assert.Equal(t, EventData{Before: &after}, data)
+}
@@ -2,56 +2,84 @@ package company
 
 import (
 	"context"
+	"regexp"
+	"time"
 
+	"github.com/a-romancev/crud_task/internal/event"
+	platform "github.com/a-romancev/crud_task/internal/platform/mongo"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	collection = "companies"
+	collection        = "companies"
+	historyCollection = "company_history"
 )
 
+// Mongo stores companies together with their history and events. Every write is a transaction,
+// so Mongo has to be a replica set.
 type Mongo struct {
-	db *mongo.Database
+	db     *mongo.Database
+	outbox *event.Outbox
 }
 
 func NewMongo(db *mongo.Database) *Mongo {
 	return &Mongo{
-		db: db,
+		db:     db,
+		outbox: event.NewOutbox(db),
 	}
 }
 
 func (m Mongo) Create(ctx context.Context, request Company) (Company, error) {
-	_, err := m.db.Collection(collection).InsertOne(ctx, request)
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	request.TenantID = tenant
+	request.Version = 1
+	request.CreatedAt = platform.Now()
+	request.UpdatedAt = request.CreatedAt
+	request.DeletedAt = nil
+	err = transaction(ctx, m.db, func(ctx context.Context) error {
+		_, err := m.db.Collection(collection).InsertOne(ctx, request)
+		if err != nil {
+			return err
+		}
+		return m.record(ctx, NewChange(ctx, OpCreate, nil, &request))
+	})
 	switch {
 	case mongo.IsDuplicateKeyError(err):
 		return Company{}, ErrDuplicatedEntry
 	case err != nil:
-		return Company{}, err
+		return Company{}, storageError(err)
 	}
 
 	return request, nil
 }
 
 func (m Mongo) Fetch(ctx context.Context, lookup Lookup) ([]Company, error) {
-	filter := make(bson.M)
-
-	if lookup.ID != uuid.Nil {
-		filter["_id"] = lookup.ID
-	}
-	cur, err := m.db.Collection(collection).Find(
-		ctx,
-		filter,
-		&options.FindOptions{
-			Sort: bson.M{"_id": 1},
-		},
-	)
+	tenant, err := TenantFrom(ctx)
 	if err != nil {
 		return nil, err
 	}
+	opts := options.Find().SetSort(sortOf(lookup.Sort))
+	if lookup.Limit > 0 {
+		opts.SetLimit(int64(lookup.Limit))
+	}
+	if skip := lookup.skip(); skip > 0 {
+		opts.SetSkip(int64(skip))
+	}
+	if lookup.Query != "" {
+		opts.SetProjection(bson.M{SortRelevance: textScore})
+	}
+	cur, err := m.db.Collection(collection).Find(ctx, filterOf(tenant, lookup), opts)
+	if err != nil {
+		return nil, storageError(err)
+	}
 	defer cur.Close(ctx)
 
 	var companies []Company
@@ -63,17 +91,38 @@ func (m Mongo) Fetch(ctx context.Context, lookup Lookup) ([]Company, error) {
 		}
 		companies = append(companies, c)
 	}
+	if cur.Err() != nil {
+		return nil, storageError(cur.Err())
+	}
 
 	return companies, nil
 }
 
+// Count returns the number of companies matching the lookup filters. Paging fields are ignored.
+func (m Mongo) Count(ctx context.Context, lookup Lookup) (int64, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return 0, err
+	}
+	lookup.After = nil
+	n, err := m.db.Collection(collection).CountDocuments(ctx, filterOf(tenant, lookup))
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return n, nil
+}
+
+// FetchOne returns the company with the lookup ID, ErrNotFound is returned when the ID is missing.
 func (m Mongo) FetchOne(ctx context.Context, lookup Lookup) (Company, error) {
+	if lookup.ID == uuid.Nil {
+		return Company{}, ErrNotFound
+	}
 	companies, err := m.Fetch(ctx, lookup)
 	if err != nil {
 		return Company{}, err
 	}
 	if len(companies) == 0 {
-		return Company{}, errors.New("not found")
+		return Company{}, ErrNotFound
 	}
 	if len(companies) > 1 {
 		return Company{}, errors.New("unexpected result")
@@ -81,35 +130,443 @@ func (m Mongo) FetchOne(ctx context.Context, lookup Lookup) (Company, error) {
 	return companies[0], nil
 }
 
-func (m Mongo) UpdateOne(ctx context.Context, lookup Lookup, request Company) (Company, error) {
+// UpdateOne applies the patch to the company, increments its version and returns the updated company.
+// ErrTestFailed is returned when the company does not match the patch expectations
+// and ErrVersionMismatch when it does not have the lookup version.
+func (m Mongo) UpdateOne(ctx context.Context, lookup Lookup, patch Patch) (Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	filter, err := conditionOf(tenant, lookup)
+	if err != nil {
+		return Company{}, err
+	}
+	for f, v := range patch.Expect {
+		if v == nil {
+			v = bson.M{"$in": bson.A{nil, ""}}
+		}
+		filter[f] = v
+	}
+
+	if patch.Empty() {
+		c, err := m.fetchOne(ctx, filter)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Company{}, m.mismatch(ctx, lookup)
+		}
+		return c, err
+	}
+	updatedAt := platform.Now()
+	set := bson.M{"updated_at": updatedAt}
+	for f, v := range patch.Set {
+		set[f] = v
+	}
 	update := bson.M{
-		"$set": request,
+		"$set": set,
+		"$inc": bson.M{"version": 1},
 	}
-	filter := make(bson.M)
-	if lookup.ID != uuid.Nil {
-		filter["_id"] = lookup.ID
+	if len(patch.Unset) > 0 {
+		unset := make(bson.M)
+		for _, f := range patch.Unset {
+			unset[f] = ""
+		}
+		update["$unset"] = unset
 	}
-	res := m.db.Collection(collection).FindOneAndUpdate(ctx, filter, update)
-	if res.Err() != nil {
-		return Company{}, res.Err()
+	c, err := m.write(ctx, OpUpdate, filter, update, func(c Company) Company {
+		c = c.apply(patch)
+		c.UpdatedAt = updatedAt
+		return c
+	})
+	if err != nil {
+		return Company{}, m.writeError(ctx, lookup, err)
 	}
+	return c, nil
+}
 
-	var c Company
-	err := res.Decode(&c)
+// DeleteOne soft deletes the company and returns its last state.
+// ErrVersionMismatch is returned when it does not have the lookup version.
+func (m Mongo) DeleteOne(ctx context.Context, lookup Lookup) (Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	deletedAt := platform.Now()
+	update := bson.M{
+		"$set": bson.M{"deleted_at": deletedAt, "updated_at": deletedAt},
+		"$inc": bson.M{"version": 1},
+	}
+	filter, err := conditionOf(tenant, lookup)
 	if err != nil {
 		return Company{}, err
 	}
+	c, err := m.write(ctx, OpDelete, filter, update, func(c Company) Company {
+		c.DeletedAt = &deletedAt
+		c.UpdatedAt = deletedAt
+		return c
+	})
+	if err != nil {
+		return Company{}, m.writeError(ctx, lookup, err)
+	}
 	return c, nil
 }
 
-func (m Mongo) DeleteOne(ctx context.Context, lookup Lookup) error {
-	filter := make(bson.M)
+// Restore undoes the soft deletion of the company. ErrDuplicatedEntry is returned
+// when another company has taken its name in the meantime.
+func (m Mongo) Restore(ctx context.Context, lookup Lookup) (Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	if lookup.ID == uuid.Nil {
+		return Company{}, ErrNotFound
+	}
+	filter := bson.M{
+		"tenant_id":  tenant,
+		"_id":        lookup.ID,
+		"deleted_at": bson.M{"$ne": nil},
+	}
+	if lookup.Version != 0 {
+		filter["version"] = lookup.Version
+	}
+	restrict(filter, lookup)
+	restoredAt := platform.Now()
+	update := bson.M{
+		"$set": bson.M{"deleted_at": nil, "updated_at": restoredAt},
+		"$inc": bson.M{"version": 1},
+	}
+	c, err := m.write(ctx, OpRestore, filter, update, func(c Company) Company {
+		c.DeletedAt = nil
+		c.UpdatedAt = restoredAt
+		return c
+	})
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return c, m.writeError(ctx, lookup, err)
+	}
+
+	c, err = m.FetchOne(ctx, Lookup{ID: lookup.ID, IncludeDeleted: true})
+	switch {
+	case err != nil:
+		return Company{}, err
+	case !lookup.permits(c):
+		return Company{}, ErrForbidden
+	case c.DeletedAt == nil:
+		return Company{}, ErrNotDeleted
+	default:
+		return Company{}, ErrVersionMismatch
+	}
+}
+
+// SetCollaborators replaces the collaborators of the company.
+// ErrVersionMismatch is returned when it does not have the lookup version.
+func (m Mongo) SetCollaborators(ctx context.Context, lookup Lookup, collaborators []uuid.UUID) (Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	updatedAt := platform.Now()
+	update := bson.M{
+		"$set": bson.M{"collaborators": collaborators, "updated_at": updatedAt},
+		"$inc": bson.M{"version": 1},
+	}
+	filter, err := conditionOf(tenant, lookup)
+	if err != nil {
+		return Company{}, err
+	}
+	c, err := m.write(ctx, OpUpdate, filter, update, func(c Company) Company {
+		c.Collaborators = collaborators
+		c.UpdatedAt = updatedAt
+		return c
+	})
+	if err != nil {
+		return Company{}, m.writeError(ctx, lookup, err)
+	}
+	return c, nil
+}
+
+// PurgeAllTenants permanently removes companies of every tenant soft deleted before the given time.
+// It is the only method not scoped by the context tenant, as the retention of deleted companies
+// is the same for all of them. Purges leave the history of the companies and publish no events.
+func (m Mongo) PurgeAllTenants(ctx context.Context, before time.Time) (int64, error) {
+	res, err := m.db.Collection(collection).DeleteMany(ctx, bson.M{
+		"deleted_at": bson.M{"$lte": before},
+	})
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return res.DeletedCount, nil
+}
+
+func filterOf(tenant string, lookup Lookup) bson.M {
+	filter := bson.M{"tenant_id": tenant}
+	if !lookup.IncludeDeleted {
+		filter["deleted_at"] = nil
+	}
 	if lookup.ID != uuid.Nil {
 		filter["_id"] = lookup.ID
 	}
-	_, err := m.db.Collection(collection).DeleteOne(ctx, filter)
+	if lookup.Type != nil {
+		filter["type"] = *lookup.Type
+	}
+	if lookup.Registered != nil {
+		filter["registered"] = *lookup.Registered
+	}
+	if lookup.OwnerID != uuid.Nil {
+		filter["owner_id"] = lookup.OwnerID
+	}
+	employees := make(bson.M)
+	if lookup.EmployeesMin != nil {
+		employees["$gte"] = *lookup.EmployeesMin
+	}
+	if lookup.EmployeesMax != nil {
+		employees["$lte"] = *lookup.EmployeesMax
+	}
+	if len(employees) > 0 {
+		filter["employees_num"] = employees
+	}
+	if lookup.Query != "" {
+		filter["$or"] = bson.A{
+			bson.M{"name": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(lookup.Query), Options: "i"}},
+			bson.M{"$text": bson.M{"$search": lookup.Query}},
+		}
+	}
+	if lookup.After != nil && lookup.Sort.Field != SortRelevance {
+		filter["$and"] = bson.A{afterCursor(*lookup.After)}
+	}
+	return filter
+}
+
+var textScore = bson.M{"$meta": "textScore"}
+
+// afterCursor matches companies following the cursor in its sort order.
+// Mongo sorts nulls first, so they have to be handled separately for ascending and descending order.
+func afterCursor(cur Cursor) bson.M {
+	cmp := "$gt"
+	if cur.Sort.Desc {
+		cmp = "$lt"
+	}
+	if cur.Sort.Field == "" || cur.Sort.Field == SortID {
+		return bson.M{SortID: bson.M{cmp: cur.ID}}
+	}
+
+	f := cur.Sort.Field
+	sameValue := bson.M{f: cur.Value, SortID: bson.M{cmp: cur.ID}}
+	switch {
+	case cur.Value == nil && cur.Sort.Desc:
+		return sameValue
+	case cur.Value == nil:
+		return bson.M{"$or": bson.A{sameValue, bson.M{f: bson.M{"$ne": nil}}}}
+	case cur.Sort.Desc:
+		return bson.M{"$or": bson.A{bson.M{f: bson.M{cmp: cur.Value}}, sameValue, bson.M{f: nil}}}
+	default:
+		return bson.M{"$or": bson.A{bson.M{f: bson.M{cmp: cur.Value}}, sameValue}}
+	}
+}
+
+// sortOf always ends with _id so that the order is total and paging is deterministic.
+func sortOf(s Sort) bson.D {
+	dir := 1
+	if s.Desc {
+		dir = -1
+	}
+	if s.Field == "" || s.Field == SortID {
+		return bson.D{{Key: SortID, Value: dir}}
+	}
+	if s.Field == SortRelevance {
+		return bson.D{{Key: SortRelevance, Value: textScore}, {Key: SortID, Value: 1}}
+	}
+	return bson.D{{Key: s.Field, Value: dir}, {Key: SortID, Value: dir}}
+}
+
+// History returns changes of a company, newest first.
+func (m Mongo) History(ctx context.Context, lookup HistoryLookup) ([]Change, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	if lookup.Limit > 0 {
+		opts.SetLimit(int64(lookup.Limit))
+	}
+	if lookup.Offset > 0 {
+		opts.SetSkip(int64(lookup.Offset))
+	}
+	cur, err := m.db.Collection(historyCollection).Find(ctx, bson.M{"tenant_id": tenant, "company_id": lookup.CompanyID}, opts)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer cur.Close(ctx)
+
+	var changes []Change
+	for cur.Next(ctx) {
+		var c Change
+		err := cur.Decode(&c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	if cur.Err() != nil {
+		return nil, storageError(cur.Err())
+	}
+	return changes, nil
+}
+
+// CountHistory returns the number of changes of a company. Paging fields are ignored.
+func (m Mongo) CountHistory(ctx context.Context, lookup HistoryLookup) (int64, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.db.Collection(historyCollection).CountDocuments(ctx, bson.M{"tenant_id": tenant, "company_id": lookup.CompanyID})
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return n, nil
+}
+
+// AsOf reconstructs the company as it was at the given time. Soft deleted companies are returned
+// with DeletedAt set, ErrNotFound is returned when the company did not exist yet.
+func (m Mongo) AsOf(ctx context.Context, id uuid.UUID, at time.Time) (Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return Company{}, err
+	}
+	var c Change
+	err = m.db.Collection(historyCollection).FindOne(
+		ctx,
+		bson.M{"tenant_id": tenant, "company_id": id, "time": bson.M{"$lte": at}},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&c)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return Company{}, ErrNotFound
+	case err != nil:
+		return Company{}, storageError(err)
+	case c.After == nil:
+		return Company{}, ErrNotFound
+	}
+	return *c.After, nil
+}
+
+// record stores the change in the history and its event in the outbox. It has to be called within
+// the transaction of the write, so that the company, its history and events never diverge.
+func (m Mongo) record(ctx context.Context, c Change) error {
+	_, err := m.db.Collection(historyCollection).InsertOne(ctx, c)
+	if err != nil {
+		return err
+	}
+	ev, err := EventOf(c)
+	if err != nil {
+		return err
+	}
+	return m.outbox.Add(ctx, ev.WithTrace(event.TraceFrom(ctx)))
+}
+
+// transaction runs fn in a transaction, ctx passed to fn has to be used for all of its operations.
+// Errors of fn are returned as they are.
+func transaction(ctx context.Context, db *mongo.Database, fn func(ctx context.Context) error) error {
+	sess, err := db.Client().StartSession()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// write updates a single company and records the change in its history. The updated company is derived
+// from the previous one by next instead of being read again, so that both states are known for the record.
+func (m Mongo) write(ctx context.Context, op string, filter, update bson.M, next func(Company) Company) (Company, error) {
+	var after Company
+	err := transaction(ctx, m.db, func(ctx context.Context) error {
+		var before Company
+		err := m.db.Collection(collection).FindOneAndUpdate(ctx, filter, update).Decode(&before)
+		if err != nil {
+			return err
+		}
+		after = next(before)
+		after.Version++
+		return m.record(ctx, NewChange(ctx, op, &before, &after))
+	})
+	if err != nil {
+		return Company{}, err
+	}
+	return after, nil
+}
+
+// writeError maps errors of a company write, see write.
+func (m Mongo) writeError(ctx context.Context, lookup Lookup, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicatedEntry
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return m.mismatch(ctx, lookup)
+	default:
+		return storageError(err)
+	}
+}
+
+func (m Mongo) fetchOne(ctx context.Context, filter bson.M) (Company, error) {
+	var c Company
+	err := m.db.Collection(collection).FindOne(ctx, filter).Decode(&c)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return Company{}, err
+	case err != nil:
+		return Company{}, storageError(err)
+	}
+	return c, nil
+}
+
+// mismatch tells why a conditional write has not matched the company.
+func (m Mongo) mismatch(ctx context.Context, lookup Lookup) error {
+	c, err := m.FetchOne(ctx, Lookup{ID: lookup.ID})
+	if err != nil {
+		return err
+	}
+	if !lookup.permits(c) {
+		return ErrForbidden
+	}
+	if lookup.Version != 0 && c.Version != lookup.Version {
+		return ErrVersionMismatch
+	}
+	return ErrTestFailed
+}
+
+// storageError marks errors caused by Mongo being unreachable with ErrUnavailable.
+func storageError(err error) error {
+	return platform.StorageError(err, ErrUnavailable)
+}
+
+// conditionOf returns the filter of a single company write. Soft deleted companies are never written.
+// A write without an ID is refused with ErrNotFound, as it would match any company.
+func conditionOf(tenant string, lookup Lookup) (bson.M, error) {
+	if lookup.ID == uuid.Nil {
+		return nil, ErrNotFound
+	}
+	filter := bson.M{
+		"tenant_id":  tenant,
+		"_id":        lookup.ID,
+		"deleted_at": nil,
+	}
+	if lookup.Version != 0 {
+		filter["version"] = lookup.Version
+	}
+	restrict(filter, lookup)
+	return filter, nil
+}
+
+// restrict limits a write to companies of the lookup owner or editor.
+func restrict(filter bson.M, lookup Lookup) {
+	if lookup.OwnerID != uuid.Nil {
+		filter["owner_id"] = lookup.OwnerID
+	}
+	if lookup.Editor != uuid.Nil {
+		filter["$or"] = bson.A{bson.M{"owner_id": lookup.Editor}, bson.M{"collaborators": lookup.Editor}}
+	}
 }
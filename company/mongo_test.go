@@ -2,8 +2,11 @@ package company
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/a-romancev/crud_task/internal/event"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,7 +15,7 @@ import (
 func TestMongo(t *testing.T) {
 	t.Parallel()
 
-	ctx := context.Background()
+	ctx := WithTenant(context.Background(), "test")
 
 	t.Run("Create", func(t *testing.T) {
 		t.Run("Happy path", func(t *testing.T) {
@@ -66,8 +69,9 @@ func TestMongo(t *testing.T) {
 				Name: uuid.NewString(),
 			},
 		}
-		for _, c := range created {
-			_, err := companies.Create(ctx, c)
+		for i, c := range created {
+			var err error
+			created[i], err = companies.Create(ctx, c)
 			require.NoError(t, err)
 		}
 
@@ -75,4 +79,382 @@ func TestMongo(t *testing.T) {
 		require.NoError(t, err)
 		assert.ElementsMatch(t, created, fetched)
 	})
+
+	t.Run("Fetch with filters and paging", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+
+		corp, nonProfit := Types[0], Types[1]
+		small, big := 5, 500
+		yes := true
+		created := []Company{
+			{ID: uuid.New(), Name: "a", Type: &corp, EmployeesNum: &small, Registered: &yes},
+			{ID: uuid.New(), Name: "b", Type: &corp, EmployeesNum: &big, Registered: &yes},
+			{ID: uuid.New(), Name: "c", Type: &nonProfit, EmployeesNum: &big, Registered: &yes},
+		}
+		for i, c := range created {
+			var err error
+			created[i], err = companies.Create(ctx, c)
+			require.NoError(t, err)
+		}
+
+		lookup := Lookup{Type: &corp}
+		fetched, err := companies.Fetch(ctx, lookup)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, created[:2], fetched)
+
+		lookup = Lookup{EmployeesMin: &big, Sort: Sort{Field: SortName, Desc: true}}
+		fetched, err = companies.Fetch(ctx, lookup)
+		require.NoError(t, err)
+		assert.Equal(t, []Company{created[2], created[1]}, fetched)
+
+		lookup = Lookup{Sort: Sort{Field: SortName}, Limit: 1, Offset: 1}
+		fetched, err = companies.Fetch(ctx, lookup)
+		require.NoError(t, err)
+		assert.Equal(t, []Company{created[1]}, fetched)
+		total, err := companies.Count(ctx, lookup)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, total)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+
+		created := []Company{
+			{ID: uuid.New(), Name: "Acme", Description: "rockets"},
+			{ID: uuid.New(), Name: "Bolt", Description: "supplier of rockets"},
+			{ID: uuid.New(), Name: "Cargo", Description: "trucks"},
+		}
+		for i, c := range created {
+			var err error
+			created[i], err = companies.Create(ctx, c)
+			require.NoError(t, err)
+		}
+
+		// Descriptions share no word with the name prefix, so that only the name matches it.
+		fetched, err := companies.Fetch(ctx, Lookup{Query: "acm", Sort: Sort{Field: SortName}})
+		require.NoError(t, err)
+		require.Len(t, fetched, 1)
+		assert.Equal(t, created[0].ID, fetched[0].ID)
+
+		// Only the description matches a word which no name starts with.
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "supplier", Sort: Sort{Field: SortName}})
+		require.NoError(t, err)
+		require.Len(t, fetched, 1)
+		assert.Equal(t, created[1].ID, fetched[0].ID)
+
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "rockets", Sort: Sort{Field: SortRelevance}})
+		require.NoError(t, err)
+		require.Len(t, fetched, 2)
+		assert.Equal(t, created[0].ID, fetched[0].ID)
+		assert.Greater(t, fetched[0].Score, fetched[1].Score)
+
+		// Deleted companies are not covered by the unique name index, searching them needs another one.
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created[0].ID})
+		require.NoError(t, err)
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "acm", Sort: Sort{Field: SortName}})
+		require.NoError(t, err)
+		assert.Empty(t, fetched)
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "acm", IncludeDeleted: true, Sort: Sort{Field: SortName}})
+		require.NoError(t, err)
+		require.Len(t, fetched, 1)
+		assert.Equal(t, created[0].ID, fetched[0].ID)
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "rockets", IncludeDeleted: true, Sort: Sort{Field: SortRelevance}})
+		require.NoError(t, err)
+		assert.Len(t, fetched, 2)
+
+		// The text index is prefixed by the tenant, so that searches never see companies of other tenants.
+		_, err = companies.Create(WithTenant(ctx, "other"), Company{ID: uuid.New(), Name: "Dune", Description: "rockets"})
+		require.NoError(t, err)
+		fetched, err = companies.Fetch(ctx, Lookup{Query: "rockets", IncludeDeleted: true, Sort: Sort{Field: SortRelevance}})
+		require.NoError(t, err)
+		assert.Len(t, fetched, 2)
+	})
+
+	t.Run("UpdateOne", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+
+		employees := 10
+		created, err := companies.Create(ctx, Company{
+			ID:           uuid.New(),
+			Name:         uuid.NewString(),
+			Description:  "description",
+			EmployeesNum: &employees,
+		})
+		require.NoError(t, err)
+
+		updated, err := companies.UpdateOne(ctx, Lookup{ID: created.ID}, Patch{
+			Set:   map[string]interface{}{"description": "new"},
+			Unset: []string{"employees_num"},
+		})
+		require.NoError(t, err)
+		expected := created
+		expected.Description = "new"
+		expected.EmployeesNum = nil
+		expected.Version = 2
+		expected.UpdatedAt = updated.UpdatedAt
+		assert.Equal(t, expected, updated)
+		assert.False(t, updated.UpdatedAt.Before(created.UpdatedAt))
+	})
+
+	t.Run("Conditional writes", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+
+		employees := 40
+		created, err := companies.Create(ctx, Company{
+			ID:           uuid.New(),
+			Name:         uuid.NewString(),
+			EmployeesNum: &employees,
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, created.Version)
+
+		_, err = companies.UpdateOne(ctx, Lookup{ID: created.ID}, Patch{
+			Set:    map[string]interface{}{"employees_num": 50},
+			Expect: map[string]interface{}{"employees_num": 41},
+		})
+		require.ErrorIs(t, err, ErrTestFailed)
+
+		_, err = companies.UpdateOne(ctx, Lookup{ID: created.ID, Version: 2}, Patch{
+			Set: map[string]interface{}{"employees_num": 50},
+		})
+		require.ErrorIs(t, err, ErrVersionMismatch)
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID, Version: 2})
+		require.ErrorIs(t, err, ErrVersionMismatch)
+
+		updated, err := companies.UpdateOne(ctx, Lookup{ID: created.ID, Version: 1}, Patch{
+			Set:    map[string]interface{}{"employees_num": 50},
+			Expect: map[string]interface{}{"employees_num": 40},
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, updated.Version)
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID, Version: 2})
+		require.NoError(t, err)
+	})
+
+	t.Run("Soft delete", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+
+		created, err := companies.Create(ctx, Company{
+			ID:   uuid.New(),
+			Name: "test",
+		})
+		require.NoError(t, err)
+		deleted, err := companies.DeleteOne(ctx, Lookup{ID: created.ID})
+		require.NoError(t, err)
+		require.NotNil(t, deleted.DeletedAt)
+
+		_, err = companies.FetchOne(ctx, Lookup{ID: created.ID})
+		require.ErrorIs(t, err, ErrNotFound)
+		fetched, err := companies.FetchOne(ctx, Lookup{ID: created.ID, IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.Equal(t, deleted, fetched)
+
+		// The name of a deleted company can be taken, which blocks its restoration.
+		taken, err := companies.Create(ctx, Company{
+			ID:   uuid.New(),
+			Name: "test",
+		})
+		require.NoError(t, err)
+		_, err = companies.Restore(ctx, Lookup{ID: created.ID})
+		require.ErrorIs(t, err, ErrDuplicatedEntry)
+
+		_, err = companies.DeleteOne(ctx, Lookup{ID: taken.ID})
+		require.NoError(t, err)
+		restored, err := companies.Restore(ctx, Lookup{ID: created.ID})
+		require.NoError(t, err)
+		assert.Nil(t, restored.DeletedAt)
+		_, err = companies.Restore(ctx, Lookup{ID: created.ID})
+		require.ErrorIs(t, err, ErrNotDeleted)
+
+		n, err := companies.PurgeAllTenants(ctx, time.Now())
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, n)
+		_, err = companies.FetchOne(ctx, Lookup{ID: taken.ID, IncludeDeleted: true})
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("History", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+		actor := Actor{UserID: uuid.New()}
+		ctx := WithActor(ctx, actor)
+
+		created, err := companies.Create(ctx, Company{
+			ID:          uuid.New(),
+			Name:        uuid.NewString(),
+			Description: "old",
+		})
+		require.NoError(t, err)
+		updated, err := companies.UpdateOne(ctx, Lookup{ID: created.ID}, Patch{
+			Set: map[string]interface{}{"description": "new"},
+		})
+		require.NoError(t, err)
+		deleted, err := companies.DeleteOne(ctx, Lookup{ID: created.ID})
+		require.NoError(t, err)
+
+		changes, err := companies.History(ctx, HistoryLookup{CompanyID: created.ID})
+		require.NoError(t, err)
+		require.Len(t, changes, 3)
+		assert.Equal(t, []string{OpDelete, OpUpdate, OpCreate}, []string{
+			changes[0].Operation, changes[1].Operation, changes[2].Operation,
+		})
+		assert.Equal(t, actor, changes[1].Actor)
+		assert.Equal(t, &created, changes[1].Before)
+		assert.Equal(t, &updated, changes[1].After)
+		assert.Equal(t, []FieldChange{{Field: "description", From: "old", To: "new"}}, changes[1].Diff)
+
+		asOf, err := companies.AsOf(ctx, created.ID, updated.UpdatedAt)
+		require.NoError(t, err)
+		assert.Equal(t, updated, asOf)
+		asOf, err = companies.AsOf(ctx, created.ID, deleted.UpdatedAt)
+		require.NoError(t, err)
+		assert.Equal(t, deleted, asOf)
+		_, err = companies.AsOf(ctx, created.ID, created.CreatedAt.Add(-time.Second))
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Outbox", func(t *testing.T) {
+		t.Parallel()
+
+		db := dockerMongo(t)
+		companies := NewMongo(db)
+		created, err := companies.Create(ctx, Company{
+			ID:   uuid.New(),
+			Name: uuid.NewString(),
+		})
+		require.NoError(t, err)
+		_, err = companies.Create(ctx, Company{
+			ID:   uuid.New(),
+			Name: created.Name,
+		})
+		require.ErrorIs(t, err, ErrDuplicatedEntry)
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID, Version: 2})
+		require.ErrorIs(t, err, ErrVersionMismatch)
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID})
+		require.NoError(t, err)
+
+		messages, err := event.NewOutbox(db).Pending(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		assert.Equal(t, EventCreated, messages[0].Event.Type)
+		assert.Equal(t, EventDeleted, messages[1].Event.Type)
+		assert.Equal(t, created.ID.String(), messages[1].Event.Subject)
+		var data EventData
+		require.NoError(t, json.Unmarshal(messages[1].Event.Data, &data))
+		assert.Equal(t, EventData{Before: &created}, data)
+
+		// Only one relay holds the lease of the outbox until it ends.
+		outbox := event.NewOutbox(db)
+		leased, err := outbox.Lease(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, leased)
+		leased, err = outbox.Lease(ctx, "b", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, leased)
+		leased, err = outbox.Lease(ctx, "a", -time.Minute)
+		require.NoError(t, err)
+		assert.True(t, leased)
+		leased, err = outbox.Lease(ctx, "b", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, leased)
+	})
+
+	t.Run("Ownership", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+		owner, collaborator, stranger := uuid.New(), uuid.New(), uuid.New()
+		created, err := NewService(companies).Create(WithActor(ctx, Actor{UserID: owner}), Company{
+			ID:   uuid.New(),
+			Name: uuid.NewString(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, owner, created.OwnerID)
+
+		owned, err := companies.Fetch(ctx, Lookup{OwnerID: owner})
+		require.NoError(t, err)
+		assert.Equal(t, []Company{created}, owned)
+
+		patch := Patch{Set: map[string]interface{}{"description": "changed"}}
+		_, err = companies.UpdateOne(ctx, Lookup{ID: created.ID, Editor: collaborator}, patch)
+		require.ErrorIs(t, err, ErrForbidden)
+		_, err = companies.SetCollaborators(ctx, Lookup{ID: created.ID, OwnerID: collaborator}, []uuid.UUID{collaborator})
+		require.ErrorIs(t, err, ErrForbidden)
+
+		shared, err := companies.SetCollaborators(ctx, Lookup{ID: created.ID, OwnerID: owner}, []uuid.UUID{collaborator})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{collaborator}, shared.Collaborators)
+		updated, err := companies.UpdateOne(ctx, Lookup{ID: created.ID, Editor: collaborator}, patch)
+		require.NoError(t, err)
+		assert.Equal(t, "changed", updated.Description)
+
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID, Editor: stranger})
+		require.ErrorIs(t, err, ErrForbidden)
+		_, err = companies.DeleteOne(ctx, Lookup{ID: created.ID, Editor: owner})
+		require.NoError(t, err)
+		_, err = companies.Restore(ctx, Lookup{ID: created.ID, Editor: stranger})
+		require.ErrorIs(t, err, ErrForbidden)
+		_, err = companies.Restore(ctx, Lookup{ID: created.ID, Editor: collaborator})
+		require.NoError(t, err)
+	})
+	t.Run("Tenants", func(t *testing.T) {
+		t.Parallel()
+
+		companies := NewMongo(dockerMongo(t))
+		other := WithTenant(ctx, "other")
+		created, err := companies.Create(ctx, Company{
+			ID:   uuid.New(),
+			Name: "test",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "test", created.TenantID)
+
+		_, err = companies.Create(other, Company{
+			ID:   uuid.New(),
+			Name: "test",
+		})
+		require.NoError(t, err, "names are unique per tenant")
+		_, err = companies.FetchOne(other, Lookup{ID: created.ID})
+		require.ErrorIs(t, err, ErrNotFound)
+		_, err = companies.DeleteOne(other, Lookup{ID: created.ID})
+		require.ErrorIs(t, err, ErrNotFound)
+		changes, err := companies.History(other, HistoryLookup{CompanyID: created.ID})
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+
+		_, err = companies.Fetch(context.Background(), Lookup{})
+		require.ErrorIs(t, err, ErrNoTenant)
+		_, err = companies.Create(context.Background(), Company{ID: uuid.New(), Name: uuid.NewString()})
+		require.ErrorIs(t, err, ErrNoTenant)
+	})
+}
+
+func TestMongo_NilID(t *testing.T) {
+	t.Parallel()
+
+	// Lookups without an ID are refused before reaching the database, which is not needed here.
+	companies := NewMongo(nil)
+	ctx := WithTenant(context.Background(), "test")
+	lookup := Lookup{OwnerID: uuid.New()}
+
+	_, err := companies.FetchOne(ctx, lookup)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = companies.UpdateOne(ctx, lookup, Patch{})
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = companies.DeleteOne(ctx, lookup)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = companies.Restore(ctx, lookup)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = companies.SetCollaborators(ctx, lookup, nil)
+	require.ErrorIs(t, err, ErrNotFound)
 }
@@ -0,0 +1,259 @@
+package company
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrTestFailed = fmt.Errorf("patch test failed: %w", ErrConflict)
+
+// Patch is a partial update of a company, keyed by field names.
+type Patch struct {
+	Set   map[string]interface{}
+	Unset []string
+	// Expect holds field values the stored company must have for the patch to apply, nil means empty.
+	Expect map[string]interface{}
+}
+
+func (p Patch) Empty() bool {
+	return len(p.Set) == 0 && len(p.Unset) == 0
+}
+
+// Diff returns the patch turning before into after. Only mutable fields are compared.
+func Diff(before, after Company) Patch {
+	p := Patch{
+		Set: make(map[string]interface{}),
+	}
+	old := before.fields()
+	for f, v := range after.fields() {
+		switch {
+		case v == nil && old[f] != nil:
+			p.Unset = append(p.Unset, f)
+		case v != nil && v != old[f]:
+			p.Set[f] = v
+		}
+	}
+	return p
+}
+
+// Operation is a JSON Patch (RFC 6902) operation.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// JSONPatch applies a JSON Patch (RFC 6902) document to the company. Only add, replace, remove and test
+// operations on top level fields are supported. Tested values are returned as expectations,
+// so that the storage can check them atomically with the update.
+func JSONPatch(c Company, doc []byte) (Company, map[string]interface{}, error) {
+	var ops []Operation
+	err := json.Unmarshal(doc, &ops)
+	if err != nil {
+		return Company{}, nil, err
+	}
+
+	fields := c.fields()
+	expect := make(map[string]interface{})
+	changed := make(map[string]bool)
+	for i, op := range ops {
+		f := strings.TrimPrefix(op.Path, "/")
+		if _, ok := fields[f]; !ok || !strings.HasPrefix(op.Path, "/") {
+			return Company{}, nil, fmt.Errorf("operation %d: unsupported path %q", i, op.Path)
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			v, err := decodeField(f, op.Value)
+			if err != nil {
+				return Company{}, nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			if op.Op == "test" {
+				if fields[f] != v {
+					return Company{}, nil, fmt.Errorf("operation %d: %w", i, ErrTestFailed)
+				}
+				// Fields changed earlier in the same patch are checked in memory only.
+				if !changed[f] {
+					expect[f] = v
+				}
+				continue
+			}
+			if op.Op == "replace" && fields[f] == nil {
+				return Company{}, nil, fmt.Errorf("operation %d: %s is empty and cannot be replaced", i, f)
+			}
+			fields[f] = v
+			changed[f] = true
+		case "remove":
+			if fields[f] == nil {
+				return Company{}, nil, fmt.Errorf("operation %d: %s is empty and cannot be removed", i, f)
+			}
+			fields[f] = nil
+			changed[f] = true
+		default:
+			return Company{}, nil, fmt.Errorf("operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+
+	patched := c
+	patched.Score = 0
+	patched.setFields(fields)
+	return patched, expect, nil
+}
+
+func decodeField(f string, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("value is missing")
+	}
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	var (
+		v   interface{}
+		err error
+	)
+	switch f {
+	case "name", "description", "type":
+		var s string
+		err = json.Unmarshal(raw, &s)
+		v = s
+	case "employees_num":
+		var i int
+		err = json.Unmarshal(raw, &i)
+		v = i
+	case "registered":
+		var b bool
+		err = json.Unmarshal(raw, &b)
+		v = b
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", f, err)
+	}
+	if v == "" {
+		return nil, nil
+	}
+	return v, nil
+}
+
+func (c *Company) setFields(f map[string]interface{}) {
+	c.Name, _ = f["name"].(string)
+	c.Description, _ = f["description"].(string)
+	c.EmployeesNum, c.Registered, c.Type = nil, nil, nil
+	if v, ok := f["employees_num"].(int); ok {
+		c.EmployeesNum = &v
+	}
+	if v, ok := f["registered"].(bool); ok {
+		c.Registered = &v
+	}
+	if v, ok := f["type"].(string); ok {
+		c.Type = &v
+	}
+}
+
+// fieldNames lists mutable fields of a company in their JSON order.
+var fieldNames = []string{"name", "description", "employees_num", "registered", "type"}
+
+// apply returns the company with the patch applied, as the storage would do it.
+func (c Company) apply(p Patch) Company {
+	f := c.fields()
+	for k, v := range p.Set {
+		f[k] = v
+	}
+	for _, k := range p.Unset {
+		f[k] = nil
+	}
+	c.setFields(f)
+	return c
+}
+
+// fields returns mutable fields of the company with empty ones set to nil.
+func (c Company) fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"name":          nil,
+		"description":   nil,
+		"employees_num": nil,
+		"registered":    nil,
+		"type":          nil,
+	}
+	if c.Name != "" {
+		f["name"] = c.Name
+	}
+	if c.Description != "" {
+		f["description"] = c.Description
+	}
+	if c.EmployeesNum != nil {
+		f["employees_num"] = *c.EmployeesNum
+	}
+	if c.Registered != nil {
+		f["registered"] = *c.Registered
+	}
+	if c.Type != nil {
+		f["type"] = *c.Type
+	}
+	return f
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) document to the company.
+// Fields missing in the document are kept, null clears them. The ID and the ownership cannot be changed.
+func MergePatch(c Company, doc []byte) (Company, error) {
+	var patch interface{}
+	err := json.Unmarshal(doc, &patch)
+	if err != nil {
+		return Company{}, err
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		return Company{}, errors.New("merge patch should be an object")
+	}
+
+	original, err := json.Marshal(c)
+	if err != nil {
+		return Company{}, err
+	}
+	var target interface{}
+	err = json.Unmarshal(original, &target)
+	if err != nil {
+		return Company{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return Company{}, err
+	}
+	var patched Company
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		return Company{}, err
+	}
+	patched.ID = c.ID
+	patched.TenantID = c.TenantID
+	patched.OwnerID = c.OwnerID
+	patched.Collaborators = c.Collaborators
+	patched.Version = c.Version
+	patched.CreatedAt = c.CreatedAt
+	patched.UpdatedAt = c.UpdatedAt
+	patched.DeletedAt = c.DeletedAt
+	patched.Score = 0
+	return patched, nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
@@ -0,0 +1,106 @@
+package company
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+
+	employees, registered, tp := 10, true, Types[0]
+	c := Company{
+		ID:           uuid.New(),
+		Name:         "name",
+		Description:  "description",
+		EmployeesNum: &employees,
+		Registered:   &registered,
+		Type:         &tp,
+	}
+
+	t.Run("Happy path", func(t *testing.T) {
+		patched, err := MergePatch(c, []byte(`{"description": "new", "employees_num": null, "_id": "`+uuid.NewString()+`"}`))
+		require.NoError(t, err)
+
+		expected := c
+		expected.Description = "new"
+		expected.EmployeesNum = nil
+		assert.Equal(t, expected, patched)
+
+		assert.Equal(t, Patch{
+			Set:   map[string]interface{}{"description": "new"},
+			Unset: []string{"employees_num"},
+		}, Diff(c, patched))
+	})
+
+	t.Run("Unknown field returns error", func(t *testing.T) {
+		_, err := MergePatch(c, []byte(`{"unknown": 1}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Not an object returns error", func(t *testing.T) {
+		_, err := MergePatch(c, []byte(`[]`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty patch changes nothing", func(t *testing.T) {
+		patched, err := MergePatch(c, []byte(`{}`))
+		require.NoError(t, err)
+		assert.Equal(t, c, patched)
+		assert.True(t, Diff(c, patched).Empty())
+	})
+}
+
+func TestJSONPatch(t *testing.T) {
+	t.Parallel()
+
+	employees, registered, tp := 40, true, Types[0]
+	c := Company{
+		ID:           uuid.New(),
+		Name:         "name",
+		EmployeesNum: &employees,
+		Registered:   &registered,
+		Type:         &tp,
+	}
+
+	t.Run("Happy path", func(t *testing.T) {
+		patched, expect, err := JSONPatch(c, []byte(`[
+			{"op": "test", "path": "/employees_num", "value": 40},
+			{"op": "replace", "path": "/employees_num", "value": 50},
+			{"op": "add", "path": "/description", "value": "new"},
+			{"op": "remove", "path": "/registered"}
+		]`))
+		require.NoError(t, err)
+
+		fifty := 50
+		expected := c
+		expected.EmployeesNum = &fifty
+		expected.Description = "new"
+		expected.Registered = nil
+		assert.Equal(t, expected, patched)
+		assert.Equal(t, map[string]interface{}{"employees_num": 40}, expect)
+	})
+
+	t.Run("Failed test returns error", func(t *testing.T) {
+		_, _, err := JSONPatch(c, []byte(`[
+			{"op": "test", "path": "/employees_num", "value": 41},
+			{"op": "replace", "path": "/employees_num", "value": 50}
+		]`))
+		assert.ErrorIs(t, err, ErrTestFailed)
+	})
+
+	t.Run("Unsupported operations return error", func(t *testing.T) {
+		for _, doc := range []string{
+			`[{"op": "move", "from": "/name", "path": "/description"}]`,
+			`[{"op": "replace", "path": "/_id", "value": "id"}]`,
+			`[{"op": "replace", "path": "/description", "value": "new"}]`,
+			`[{"op": "add", "path": "/employees_num", "value": "many"}]`,
+		} {
+			_, _, err := JSONPatch(c, []byte(doc))
+			assert.Error(t, err, doc)
+		}
+	})
+}
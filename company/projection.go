@@ -0,0 +1,185 @@
+package company
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	searchCollection    = "company_search"
+	countsCollection    = "company_type_counts"
+	projectedCollection = "projected_events"
+)
+
+// NoType is the count key of companies without a type.
+const NoType = "none"
+
+var errProjected = errors.New("event already projected")
+
+// Projection maintains the read model of companies from their events: the number of live companies
+// per tenant and type and a search collection with a text index over names and descriptions.
+type Projection struct {
+	db *mongo.Database
+}
+
+func NewProjection(db *mongo.Database) *Projection {
+	return &Projection{
+		db: db,
+	}
+}
+
+// searchEntry is the last known state of a company. Entries of deleted companies are kept,
+// so that late events cannot bring them back.
+type searchEntry struct {
+	Company   `bson:",inline"`
+	NameLower string `bson:"name_lower"`
+}
+
+// Handle projects the company event. It is idempotent: events are skipped when their ID has already
+// been projected or the projection has a newer version of the company.
+func (p *Projection) Handle(ctx context.Context, e event.Event) error {
+	if !strings.HasPrefix(e.Type, "company.") {
+		return nil
+	}
+	if e.TenantID == "" {
+		return event.Permanent(ErrNoTenant)
+	}
+	id, err := uuid.Parse(e.Subject)
+	if err != nil {
+		return event.Permanent(err)
+	}
+	var data EventData
+	err = json.Unmarshal(e.Data, &data)
+	if err != nil {
+		return event.Permanent(err)
+	}
+	next, err := entryOf(id, e, data)
+	if err != nil {
+		return event.Permanent(err)
+	}
+	key := bson.M{"_id": id, "tenant_id": e.TenantID}
+
+	err = transaction(ctx, p.db, func(ctx context.Context) error {
+		_, err := p.db.Collection(projectedCollection).InsertOne(ctx, bson.M{"_id": e.ID, "time": e.Time})
+		if mongo.IsDuplicateKeyError(err) {
+			return errProjected
+		}
+		if err != nil {
+			return err
+		}
+
+		var prev searchEntry
+		err = p.db.Collection(searchCollection).FindOne(ctx, key).Decode(&prev)
+		found := err == nil
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+		case err != nil:
+			return err
+		case prev.Version >= next.Version:
+			return nil
+		}
+		_, err = p.db.Collection(searchCollection).ReplaceOne(ctx, key, next, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+
+		if found && prev.DeletedAt == nil {
+			err = p.count(ctx, e.TenantID, prev.Type, -1)
+			if err != nil {
+				return err
+			}
+		}
+		if next.DeletedAt == nil {
+			return p.count(ctx, e.TenantID, next.Type, 1)
+		}
+		return nil
+	})
+	if errors.Is(err, errProjected) {
+		return nil
+	}
+	return storageError(err)
+}
+
+// Counts returns the number of live companies of the context tenant per type, see NoType.
+func (p *Projection) Counts(ctx context.Context) (map[string]int64, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := p.db.Collection(countsCollection).Find(ctx, bson.M{"_id.tenant_id": tenant})
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer cur.Close(ctx)
+
+	counts := make(map[string]int64)
+	for cur.Next(ctx) {
+		var c struct {
+			Key   countKey `bson:"_id"`
+			Count int64    `bson:"count"`
+		}
+		err := cur.Decode(&c)
+		if err != nil {
+			return nil, err
+		}
+		counts[c.Key.Type] = c.Count
+	}
+	if cur.Err() != nil {
+		return nil, storageError(cur.Err())
+	}
+	return counts, nil
+}
+
+// countKey identifies a count, the order of fields matters as it is the _id of the count.
+type countKey struct {
+	TenantID string `bson:"tenant_id"`
+	Type     string `bson:"type"`
+}
+
+func (p *Projection) count(ctx context.Context, tenant string, typ *string, delta int) error {
+	key := countKey{TenantID: tenant, Type: NoType}
+	if typ != nil {
+		key.Type = *typ
+	}
+	_, err := p.db.Collection(countsCollection).UpdateByID(
+		ctx,
+		key,
+		bson.M{"$inc": bson.M{"count": delta}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// entryOf returns the state of the company after the event. Deleted events carry the state before
+// the deletion, so the deletion itself is applied here.
+func entryOf(id uuid.UUID, e event.Event, data EventData) (searchEntry, error) {
+	var c Company
+	switch {
+	case e.Type == EventDeleted && data.Before != nil:
+		c = *data.Before
+		deletedAt := e.Time
+		c.DeletedAt = &deletedAt
+		c.UpdatedAt = e.Time
+	case e.Type != EventDeleted && data.After != nil:
+		c = *data.After
+	default:
+		return searchEntry{}, errors.Errorf("no company state in %s event", e.Type)
+	}
+	if c.ID != id {
+		return searchEntry{}, errors.New("company does not match the event subject")
+	}
+	c.TenantID = e.TenantID
+	if e.SubjectVersion > 0 {
+		c.Version = e.SubjectVersion
+	}
+	c.Score = 0
+	return searchEntry{Company: c, NameLower: strings.ToLower(c.Name)}, nil
+}
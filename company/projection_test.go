@@ -0,0 +1,68 @@
+package company
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/internal/event"
+	platform "github.com/a-romancev/crud_task/internal/platform/mongo"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjection(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithTenant(context.Background(), "test")
+	projection := NewProjection(dockerMongo(t))
+	corporation, nonProfit := "Corporations", "NonProfit"
+	created := Company{ID: uuid.New(), TenantID: "test", Name: "name", Type: &corporation, Version: 1, UpdatedAt: platform.Now()}
+	updated := created
+	updated.Type = &nonProfit
+	updated.Version = 2
+	other := Company{ID: uuid.New(), TenantID: "test", Name: "other", Version: 1, UpdatedAt: platform.Now()}
+
+	createdEvent, err := EventOf(NewChange(ctx, OpCreate, nil, &created))
+	require.NoError(t, err)
+	updatedEvent, err := EventOf(NewChange(ctx, OpUpdate, &created, &updated))
+	require.NoError(t, err)
+	otherEvent, err := EventOf(NewChange(ctx, OpCreate, nil, &other))
+	require.NoError(t, err)
+
+	require.NoError(t, projection.Handle(ctx, createdEvent))
+	require.NoError(t, projection.Handle(ctx, createdEvent))
+	require.NoError(t, projection.Handle(ctx, otherEvent))
+	counts, err := projection.Counts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{corporation: 1, NoType: 1}, counts)
+
+	require.NoError(t, projection.Handle(ctx, updatedEvent))
+	stale := createdEvent
+	stale.ID = uuid.NewString()
+	require.NoError(t, projection.Handle(ctx, stale))
+	counts, err = projection.Counts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{corporation: 0, nonProfit: 1, NoType: 1}, counts)
+
+	deleted := updated
+	deleted.Version = 3
+	deleted.UpdatedAt = updated.UpdatedAt.Add(time.Second)
+	deletedEvent, err := EventOf(NewChange(ctx, OpDelete, &updated, &deleted))
+	require.NoError(t, err)
+	require.NoError(t, projection.Handle(ctx, deletedEvent))
+	counts, err = projection.Counts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{corporation: 0, nonProfit: 0, NoType: 1}, counts)
+
+	counts, err = projection.Counts(WithTenant(ctx, "other"))
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+	_, err = projection.Counts(context.Background())
+	assert.ErrorIs(t, err, ErrNoTenant)
+
+	untenanted := createdEvent
+	untenanted.TenantID = ""
+	assert.ErrorIs(t, projection.Handle(ctx, untenanted), event.ErrPermanent)
+}
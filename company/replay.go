@@ -0,0 +1,127 @@
+package company
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventSnapshot carries the current state of a company in EventData.After. Snapshots are published
+// when events are replayed to rebuild consumers, they are not recorded in the history.
+const EventSnapshot = "company.snapshot.v1"
+
+// SnapshotOf returns a snapshot event of the company. Every snapshot has a new ID,
+// consumers tell stale snapshots by the subject version.
+func SnapshotOf(c Company) (event.Event, error) {
+	e, err := event.New(uuid.NewString(), EventSource, EventSnapshot, c.ID.String(), time.Now().UTC(), EventData{After: &c})
+	if err != nil {
+		return event.Event{}, err
+	}
+	e.SubjectVersion = c.Version
+	e.TenantID = c.TenantID
+	return e, nil
+}
+
+// Checkpoint is the position of a scan, Version is only used when scanning the history.
+type Checkpoint struct {
+	CompanyID uuid.UUID `json:"company_id"`
+	Version   int64     `json:"version,omitempty"`
+}
+
+// ScanLookup selects companies, soft deleted ones included, or their changes for a replay.
+type ScanLookup struct {
+	// Type filters by company type. Changes match by the state after them, deletions by the state before.
+	Type *string
+	// From is the first company ID and To the one following the last, uuid.Nil means unbounded.
+	From uuid.UUID
+	To   uuid.UUID
+	// After resumes the scan following the checkpoint.
+	After *Checkpoint
+	Limit int
+}
+
+// Scan returns companies in the order of their IDs.
+func (m Mongo) Scan(ctx context.Context, lookup ScanLookup) ([]Company, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := scanFilter(tenant, lookup, "_id")
+	if lookup.Type != nil {
+		filter["type"] = *lookup.Type
+	}
+	if lookup.After != nil {
+		filter["$and"] = bson.A{bson.M{"_id": bson.M{"$gt": lookup.After.CompanyID}}}
+	}
+	cur, err := m.db.Collection(collection).Find(
+		ctx,
+		filter,
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(lookup.Limit)),
+	)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var companies []Company
+	err = cur.All(ctx, &companies)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return companies, nil
+}
+
+// ScanHistory returns changes in descending order of company IDs, so that the unique index on
+// company versions serves the sort. Changes of a company are in the order of their versions.
+func (m Mongo) ScanHistory(ctx context.Context, lookup ScanLookup) ([]Change, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := scanFilter(tenant, lookup, "company_id")
+	if lookup.Type != nil {
+		filter["$or"] = bson.A{
+			bson.M{"after.type": *lookup.Type},
+			bson.M{"after": nil, "before.type": *lookup.Type},
+		}
+	}
+	if lookup.After != nil {
+		filter["$and"] = bson.A{bson.M{"$or": bson.A{
+			bson.M{"company_id": bson.M{"$lt": lookup.After.CompanyID}},
+			bson.M{"company_id": lookup.After.CompanyID, "version": bson.M{"$gt": lookup.After.Version}},
+		}}}
+	}
+	cur, err := m.db.Collection(historyCollection).Find(
+		ctx,
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "company_id", Value: -1}, {Key: "version", Value: 1}}).
+			SetLimit(int64(lookup.Limit)),
+	)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var changes []Change
+	err = cur.All(ctx, &changes)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return changes, nil
+}
+
+func scanFilter(tenant string, lookup ScanLookup, idField string) bson.M {
+	filter := bson.M{"tenant_id": tenant}
+	ids := make(bson.M)
+	if lookup.From != uuid.Nil {
+		ids["$gte"] = lookup.From
+	}
+	if lookup.To != uuid.Nil {
+		ids["$lt"] = lookup.To
+	}
+	if len(ids) > 0 {
+		filter[idField] = ids
+	}
+	return filter
+}
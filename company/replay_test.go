@@ -0,0 +1,100 @@
+package company
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotOf(t *testing.T) {
+	t.Parallel()
+
+	c := Company{ID: uuid.New(), TenantID: "test", Name: "name", Version: 3}
+	e, err := SnapshotOf(c)
+	require.NoError(t, err)
+	assert.Equal(t, EventSnapshot, e.Type)
+	assert.Equal(t, "test", e.TenantID)
+	assert.Equal(t, c.ID.String(), e.Subject)
+	assert.EqualValues(t, 3, e.SubjectVersion)
+	var data EventData
+	require.NoError(t, json.Unmarshal(e.Data, &data))
+	assert.Equal(t, EventData{After: &c}, data)
+
+	other, err := SnapshotOf(c)
+	require.NoError(t, err)
+	assert.NotEqual(t, e.ID, other.ID)
+}
+
+func TestMongo_Scan(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithTenant(context.Background(), "test")
+	companies := NewMongo(dockerMongo(t))
+	corporation := "Corporations"
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		c := Company{ID: uuid.New(), Name: uuid.NewString()[:8]}
+		if i > 0 {
+			c.Type = &corporation
+		}
+		_, err := companies.Create(ctx, c)
+		require.NoError(t, err)
+		ids = append(ids, c.ID)
+	}
+	_, err := companies.DeleteOne(ctx, Lookup{ID: ids[2]})
+	require.NoError(t, err)
+	sorted := append([]uuid.UUID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+
+	t.Run("Companies", func(t *testing.T) {
+		scanned, err := companies.Scan(ctx, ScanLookup{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, scanned, 2)
+		assert.Equal(t, sorted[:2], []uuid.UUID{scanned[0].ID, scanned[1].ID})
+
+		scanned, err = companies.Scan(ctx, ScanLookup{After: &Checkpoint{CompanyID: scanned[1].ID}, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, scanned, 1)
+		assert.Equal(t, sorted[2], scanned[0].ID)
+
+		scanned, err = companies.Scan(ctx, ScanLookup{Type: &corporation, From: sorted[1], Limit: 10})
+		require.NoError(t, err)
+		for _, c := range scanned {
+			assert.Equal(t, corporation, *c.Type)
+			assert.GreaterOrEqual(t, c.ID.String(), sorted[1].String())
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		changes, err := companies.ScanHistory(ctx, ScanLookup{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, changes, 4)
+		assert.Equal(t, sorted[2], changes[0].CompanyID)
+		var deleted []int64
+		for _, c := range changes {
+			if c.CompanyID == ids[2] {
+				deleted = append(deleted, c.Version)
+			}
+		}
+		assert.Equal(t, []int64{1, 2}, deleted)
+
+		resumed, err := companies.ScanHistory(ctx, ScanLookup{
+			After: &Checkpoint{CompanyID: changes[0].CompanyID, Version: changes[0].Version},
+			Limit: 10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, changes[1:], resumed)
+
+		typed, err := companies.ScanHistory(ctx, ScanLookup{Type: &corporation, To: sorted[2], Limit: 10})
+		require.NoError(t, err)
+		for _, c := range typed {
+			assert.NotEqual(t, ids[0], c.CompanyID)
+			assert.NotEqual(t, sorted[2], c.CompanyID)
+		}
+	})
+}
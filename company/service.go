@@ -0,0 +1,96 @@
+package company
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store keeps companies, it is implemented by Mongo.
+type Store interface {
+	Create(ctx context.Context, request Company) (Company, error)
+	Fetch(ctx context.Context, lookup Lookup) ([]Company, error)
+	Count(ctx context.Context, lookup Lookup) (int64, error)
+	FetchOne(ctx context.Context, lookup Lookup) (Company, error)
+	UpdateOne(ctx context.Context, lookup Lookup, patch Patch) (Company, error)
+	DeleteOne(ctx context.Context, lookup Lookup) (Company, error)
+	Restore(ctx context.Context, lookup Lookup) (Company, error)
+	SetCollaborators(ctx context.Context, lookup Lookup, collaborators []uuid.UUID) (Company, error)
+	History(ctx context.Context, lookup HistoryLookup) ([]Change, error)
+	CountHistory(ctx context.Context, lookup HistoryLookup) (int64, error)
+	AsOf(ctx context.Context, id uuid.UUID, at time.Time) (Company, error)
+}
+
+// Service applies access rules on top of the store. Companies are owned by the actor creating them,
+// only the owner, collaborators and admins can change them, and only the owner and admins can change
+// the collaborators. Rules are conditions of the writes, so that they are checked atomically with them.
+// Reads are not restricted.
+type Service struct {
+	Store
+}
+
+func NewService(store Store) *Service {
+	return &Service{
+		Store: store,
+	}
+}
+
+// Create makes the actor the owner of the company.
+func (s *Service) Create(ctx context.Context, request Company) (Company, error) {
+	a := ActorFrom(ctx)
+	if a.UserID == uuid.Nil {
+		return Company{}, ErrForbidden
+	}
+	request.OwnerID = a.UserID
+	return s.Store.Create(ctx, request)
+}
+
+func (s *Service) UpdateOne(ctx context.Context, lookup Lookup, patch Patch) (Company, error) {
+	lookup, err := editable(ctx, lookup)
+	if err != nil {
+		return Company{}, err
+	}
+	return s.Store.UpdateOne(ctx, lookup, patch)
+}
+
+func (s *Service) DeleteOne(ctx context.Context, lookup Lookup) (Company, error) {
+	lookup, err := editable(ctx, lookup)
+	if err != nil {
+		return Company{}, err
+	}
+	return s.Store.DeleteOne(ctx, lookup)
+}
+
+func (s *Service) Restore(ctx context.Context, lookup Lookup) (Company, error) {
+	lookup, err := editable(ctx, lookup)
+	if err != nil {
+		return Company{}, err
+	}
+	return s.Store.Restore(ctx, lookup)
+}
+
+func (s *Service) SetCollaborators(ctx context.Context, lookup Lookup, collaborators []uuid.UUID) (Company, error) {
+	a := ActorFrom(ctx)
+	switch {
+	case a.Admin:
+	case a.UserID == uuid.Nil:
+		return Company{}, ErrForbidden
+	default:
+		lookup.OwnerID = a.UserID
+	}
+	return s.Store.SetCollaborators(ctx, lookup, collaborators)
+}
+
+// editable restricts the lookup to companies the actor can change.
+func editable(ctx context.Context, lookup Lookup) (Lookup, error) {
+	a := ActorFrom(ctx)
+	switch {
+	case a.Admin:
+	case a.UserID == uuid.Nil:
+		return Lookup{}, ErrForbidden
+	default:
+		lookup.Editor = a.UserID
+	}
+	return lookup, nil
+}
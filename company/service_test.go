@@ -0,0 +1,98 @@
+package company
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lookupStore records lookups of writes, other methods are not implemented.
+type lookupStore struct {
+	Store
+	created Company
+	lookups []Lookup
+}
+
+func (s *lookupStore) Create(_ context.Context, c Company) (Company, error) {
+	s.created = c
+	return c, nil
+}
+
+func (s *lookupStore) UpdateOne(_ context.Context, lookup Lookup, _ Patch) (Company, error) {
+	s.lookups = append(s.lookups, lookup)
+	return Company{}, nil
+}
+
+func (s *lookupStore) DeleteOne(_ context.Context, lookup Lookup) (Company, error) {
+	s.lookups = append(s.lookups, lookup)
+	return Company{}, nil
+}
+
+func (s *lookupStore) SetCollaborators(_ context.Context, lookup Lookup, _ []uuid.UUID) (Company, error) {
+	s.lookups = append(s.lookups, lookup)
+	return Company{}, nil
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	user := uuid.New()
+	ctx := WithActor(context.Background(), Actor{UserID: user})
+	admin := WithActor(context.Background(), Actor{UserID: uuid.New(), Admin: true})
+	id := uuid.New()
+
+	t.Run("Create makes the actor the owner", func(t *testing.T) {
+		store := &lookupStore{}
+		_, err := NewService(store).Create(ctx, Company{ID: id, OwnerID: uuid.New()})
+		require.NoError(t, err)
+		assert.Equal(t, user, store.created.OwnerID)
+	})
+
+	t.Run("Writes are restricted to the actor", func(t *testing.T) {
+		store := &lookupStore{}
+		service := NewService(store)
+		_, err := service.UpdateOne(ctx, Lookup{ID: id, Version: 2}, Patch{})
+		require.NoError(t, err)
+		_, err = service.DeleteOne(ctx, Lookup{ID: id})
+		require.NoError(t, err)
+		_, err = service.SetCollaborators(ctx, Lookup{ID: id}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []Lookup{
+			{ID: id, Version: 2, Editor: user},
+			{ID: id, Editor: user},
+			{ID: id, OwnerID: user},
+		}, store.lookups)
+	})
+
+	t.Run("Admins are not restricted", func(t *testing.T) {
+		store := &lookupStore{}
+		service := NewService(store)
+		_, err := service.DeleteOne(admin, Lookup{ID: id})
+		require.NoError(t, err)
+		_, err = service.SetCollaborators(admin, Lookup{ID: id}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []Lookup{{ID: id}, {ID: id}}, store.lookups)
+	})
+
+	t.Run("Anonymous writes are forbidden", func(t *testing.T) {
+		service := NewService(&lookupStore{})
+		_, err := service.Create(context.Background(), Company{ID: id})
+		require.ErrorIs(t, err, ErrForbidden)
+		_, err = service.DeleteOne(context.Background(), Lookup{ID: id})
+		require.ErrorIs(t, err, ErrForbidden)
+	})
+}
+
+func TestCompany_EditableBy(t *testing.T) {
+	t.Parallel()
+
+	owner, collaborator := uuid.New(), uuid.New()
+	c := Company{OwnerID: owner, Collaborators: []uuid.UUID{collaborator}}
+	assert.True(t, c.EditableBy(owner))
+	assert.True(t, c.EditableBy(collaborator))
+	assert.False(t, c.EditableBy(uuid.New()))
+	assert.False(t, Company{}.EditableBy(uuid.Nil))
+}
@@ -0,0 +1,26 @@
+package company
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoTenant is returned by every storage operation whose context has no tenant.
+var ErrNoTenant = errors.New("no tenant")
+
+type tenantKey struct{}
+
+// WithTenant scopes storage operations of the context to the tenant. Companies, their history
+// and events of different tenants are isolated from each other.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of the context, ErrNoTenant when there is none.
+func TenantFrom(ctx context.Context) (string, error) {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	if tenant == "" {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
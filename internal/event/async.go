@@ -0,0 +1,178 @@
+package event
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Policies of AsyncProducer when its queue is full.
+const (
+	// OnFullBlock waits until there is room in the queue.
+	OnFullBlock = "block"
+	// OnFullDrop discards the event.
+	OnFullDrop = "drop"
+	// OnFullReject returns ErrQueueFull and reports the producer as overloaded, so that writes
+	// can be rejected until the queue drains.
+	OnFullReject = "reject"
+)
+
+var ErrQueueFull = errors.New("event queue is full")
+
+// metrics of asynchronous producers, published to admins at /debug/vars.
+var metrics = expvar.NewMap("events")
+
+type AsyncConf struct {
+	Enabled bool `mapstructure:"enabled"`
+	// QueueSize bounds the number of events produced but not yet acknowledged by Kafka.
+	QueueSize int    `mapstructure:"queue_size"`
+	OnFull    string `mapstructure:"on_full"`
+	// BatchSize and Linger trigger sending a batch, whichever comes first. Linger is required
+	// with BatchSize, otherwise an incomplete batch would never be sent.
+	BatchSize int           `mapstructure:"batch_size"`
+	Linger    time.Duration `mapstructure:"linger"`
+}
+
+// Validate checks the configuration of an enabled asynchronous producer.
+func (c AsyncConf) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.QueueSize < 1 {
+		return errors.New("async queue size not set")
+	}
+	if c.BatchSize < 0 || c.Linger < 0 {
+		return errors.New("async batching cannot be negative")
+	}
+	if c.BatchSize > 0 && c.Linger == 0 {
+		return errors.New("async linger not set")
+	}
+	switch c.OnFull {
+	case OnFullBlock, OnFullDrop, OnFullReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown async full queue policy %q", c.OnFull)
+	}
+}
+
+// AsyncSink is implemented by sinks delivering events in the background.
+type AsyncSink interface {
+	Sink
+	// ProduceAsync queues the event, done is called once it is delivered or has failed.
+	// Nothing is queued and done is never called when an error is returned.
+	ProduceAsync(e Event, done func(error)) error
+}
+
+// AsyncProducer publishes to Kafka in batches without waiting for the brokers.
+type AsyncProducer struct {
+	topic    string
+	onFull   string
+	producer sarama.AsyncProducer
+	slots    chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewAsyncProducer(conf KafkaConf) (*AsyncProducer, error) {
+	err := conf.Async.Validate()
+	if err != nil {
+		return nil, err
+	}
+	config, err := saramaConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Flush.Messages = conf.Async.BatchSize
+	config.Producer.Flush.Frequency = conf.Async.Linger
+	prd, err := sarama.NewAsyncProducer(conf.Servers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &AsyncProducer{
+		topic:    conf.Topic,
+		onFull:   conf.Async.OnFull,
+		producer: prd,
+		slots:    make(chan struct{}, conf.Async.QueueSize),
+	}
+	p.wg.Add(2)
+	go p.successes()
+	go p.failures()
+	return p, nil
+}
+
+// Produce queues the event, failures of its delivery are only counted. Under OnFullDrop
+// an event not fitting in the queue is dropped.
+func (p *AsyncProducer) Produce(e Event) error {
+	err := p.ProduceAsync(e, nil)
+	if errors.Is(err, ErrQueueFull) && p.onFull == OnFullDrop {
+		metrics.Add("dropped", 1)
+		return nil
+	}
+	return err
+}
+
+func (p *AsyncProducer) ProduceAsync(e Event, done func(error)) error {
+	msg, err := messageOf(p.topic, e)
+	if err != nil {
+		return err
+	}
+	msg.Metadata = done
+
+	if p.onFull == OnFullBlock {
+		p.slots <- struct{}{}
+	} else {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			// The caller keeps the event, so it is counted as dropped by Produce only.
+			if p.onFull == OnFullReject {
+				metrics.Add("rejected", 1)
+			}
+			return ErrQueueFull
+		}
+	}
+	metrics.Add("queued", 1)
+	p.producer.Input() <- msg
+	return nil
+}
+
+// Overloaded tells whether events are rejected because the queue is full.
+func (p *AsyncProducer) Overloaded() bool {
+	return p.onFull == OnFullReject && len(p.slots) == cap(p.slots)
+}
+
+// Close flushes queued events and waits until all of them are acknowledged.
+// Produce must not be called concurrently.
+func (p *AsyncProducer) Close() error {
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *AsyncProducer) successes() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		metrics.Add("delivered", 1)
+		p.ack(msg, nil)
+	}
+}
+
+func (p *AsyncProducer) failures() {
+	defer p.wg.Done()
+	for err := range p.producer.Errors() {
+		metrics.Add("failed", 1)
+		p.ack(err.Msg, err.Err)
+	}
+}
+
+func (p *AsyncProducer) ack(msg *sarama.ProducerMessage, err error) {
+	<-p.slots
+	metrics.Add("queued", -1)
+	if done, ok := msg.Metadata.(func(error)); ok && done != nil {
+		done(err)
+	}
+}
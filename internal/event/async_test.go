@@ -0,0 +1,88 @@
+package event
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncProducer(t *testing.T) {
+	t.Parallel()
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	p, err := NewAsyncProducer(KafkaConf{
+		Servers: []string{broker.Addr()},
+		Topic:   "topic",
+		Async:   AsyncConf{Enabled: true, QueueSize: 10, OnFull: OnFullBlock, BatchSize: 2, Linger: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	var (
+		mu   sync.Mutex
+		acks []error
+	)
+	for _, id := range []string{"1", "2", "3"} {
+		err := p.ProduceAsync(testEvent(t, id), func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			acks = append(acks, err)
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, p.Close())
+
+	assert.Equal(t, []error{nil, nil, nil}, acks)
+	assert.False(t, p.Overloaded())
+}
+
+func TestAsyncProducer_QueueFull(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{OnFullDrop, OnFullReject} {
+		p := &AsyncProducer{onFull: policy, slots: make(chan struct{}, 1)}
+		p.slots <- struct{}{}
+
+		err := p.ProduceAsync(testEvent(t, "1"), nil)
+		require.ErrorIs(t, err, ErrQueueFull, policy)
+		assert.Equal(t, policy == OnFullReject, p.Overloaded(), policy)
+	}
+
+	// Events refused by ProduceAsync are kept by the caller, only Produce drops them.
+	dropped := func() int64 {
+		v, _ := metrics.Get("dropped").(*expvar.Int)
+		if v == nil {
+			return 0
+		}
+		return v.Value()
+	}
+	before := dropped()
+	p := &AsyncProducer{onFull: OnFullDrop, slots: make(chan struct{}, 1)}
+	p.slots <- struct{}{}
+	require.ErrorIs(t, p.ProduceAsync(testEvent(t, "1"), nil), ErrQueueFull)
+	assert.Equal(t, before, dropped())
+	require.NoError(t, p.Produce(testEvent(t, "1")))
+	assert.Equal(t, before+1, dropped())
+}
+
+func TestAsyncConf_Validate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, AsyncConf{}.Validate())
+	require.NoError(t, AsyncConf{Enabled: true, QueueSize: 1, OnFull: OnFullReject}.Validate())
+	require.Error(t, AsyncConf{Enabled: true, OnFull: OnFullReject}.Validate())
+	require.Error(t, AsyncConf{Enabled: true, QueueSize: 1, OnFull: "wait"}.Validate())
+	require.Error(t, AsyncConf{Enabled: true, QueueSize: 1, OnFull: OnFullBlock, BatchSize: 10}.Validate())
+}
@@ -0,0 +1,246 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers added to events forwarded to the retry and dead letter topics.
+const (
+	HeaderAttempts = "attempts"
+	HeaderError    = "error"
+)
+
+// ErrPermanent marks failures which cannot be fixed by retrying, such events go to the dead letter topic at once.
+var ErrPermanent = errors.New("permanent failure")
+
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %v", ErrPermanent, err)
+}
+
+// Handler processes consumed events. It must be idempotent, as events are delivered at least once.
+type Handler interface {
+	Handle(ctx context.Context, e Event) error
+}
+
+type HandlerFunc func(ctx context.Context, e Event) error
+
+func (f HandlerFunc) Handle(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+type ConsumerConf struct {
+	Group           string `mapstructure:"group"`
+	RetryTopic      string `mapstructure:"retry_topic"`
+	DeadLetterTopic string `mapstructure:"dead_letter_topic"`
+	// MaxAttempts of handling an event before it goes to the dead letter topic.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// RetryDelay is the least time a failed event waits in the retry topic.
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
+}
+
+func (c ConsumerConf) Validate() error {
+	switch {
+	case c.Group == "":
+		return errors.New("consumer group not set")
+	case c.RetryTopic == "":
+		return errors.New("retry topic not set")
+	case c.DeadLetterTopic == "":
+		return errors.New("dead letter topic not set")
+	case c.MaxAttempts < 1:
+		return errors.New("max attempts not set")
+	case c.RetryDelay < 0:
+		return errors.New("retry delay cannot be negative")
+	}
+	return nil
+}
+
+// Consumer is a member of a consumer group handling events of the topic and of the retry topic.
+// Offsets are committed only after an event is handled or forwarded to the retry or dead letter topic.
+type Consumer struct {
+	topic    string
+	conf     ConsumerConf
+	handler  Handler
+	client   sarama.Client
+	group    sarama.ConsumerGroup
+	producer sarama.SyncProducer
+}
+
+func NewConsumer(kafka KafkaConf, conf ConsumerConf, handler Handler) (*Consumer, error) {
+	err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	config, err := saramaConfig(kafka)
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client, err := sarama.NewClient(kafka.Servers, config)
+	if err != nil {
+		return nil, err
+	}
+	group, err := sarama.NewConsumerGroupFromClient(conf.Group, client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = group.Close()
+		_ = client.Close()
+		return nil, err
+	}
+	return &Consumer{
+		topic:    kafka.Topic,
+		conf:     conf,
+		handler:  handler,
+		client:   client,
+		group:    group,
+		producer: producer,
+	}, nil
+}
+
+// Run consumes events until the context is done, rejoining the group after every rebalance.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		err := c.group.Consume(ctx, []string{c.topic, c.conf.RetryTopic}, c)
+		switch {
+		case errors.Is(err, sarama.ErrClosedConsumerGroup):
+			return nil
+		case err != nil:
+			return err
+		case ctx.Err() != nil:
+			return nil
+		}
+	}
+}
+
+func (c *Consumer) Close() error {
+	err := c.group.Close()
+	if perr := c.producer.Close(); err == nil {
+		err = perr
+	}
+	if cerr := c.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *Consumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := sess.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if msg.Topic == c.conf.RetryTopic && !c.delay(ctx, msg) {
+				return nil
+			}
+			err := c.process(ctx, msg)
+			if err != nil {
+				return err
+			}
+			sess.MarkMessage(msg, "")
+			sess.Commit()
+		}
+	}
+}
+
+// delay waits until the retried message is due, it returns false when the context is done first.
+func (c *Consumer) delay(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	wait := time.Until(msg.Timestamp.Add(c.conf.RetryDelay))
+	if wait <= 0 {
+		return true
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// process handles the message. A failed message is forwarded to the retry topic, or to the dead letter topic
+// once it is out of attempts. An error is returned only if the message is neither handled nor forwarded,
+// so that it is not committed.
+func (c *Consumer) process(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var e Event
+	err := json.Unmarshal(msg.Value, &e)
+	if err != nil {
+		err = Permanent(err)
+	} else {
+		err = c.handler.Handle(ctx, e)
+	}
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	attempts := attemptsOf(msg) + 1
+	topic := c.conf.RetryTopic
+	if errors.Is(err, ErrPermanent) || attempts >= c.conf.MaxAttempts {
+		topic = c.conf.DeadLetterTopic
+	}
+	log.Ctx(ctx).Warn().Err(err).
+		Str("id", e.ID).
+		Int("attempts", attempts).
+		Str("topic", topic).
+		Msg("Failed to handle event.")
+	_, _, err = c.producer.SendMessage(forwarded(msg, topic, attempts, err))
+	return err
+}
+
+func attemptsOf(msg *sarama.ConsumerMessage) int {
+	for _, h := range msg.Headers {
+		if string(h.Key) == HeaderAttempts {
+			n, _ := strconv.Atoi(string(h.Value))
+			return n
+		}
+	}
+	return 0
+}
+
+// forwarded copies the message to the topic, keeping its key so that retries of a company stay ordered.
+func forwarded(msg *sarama.ConsumerMessage, topic string, attempts int, cause error) *sarama.ProducerMessage {
+	fwd := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	for _, h := range msg.Headers {
+		key := string(h.Key)
+		if key != HeaderAttempts && key != HeaderError {
+			fwd.Headers = append(fwd.Headers, *h)
+		}
+	}
+	fwd.Headers = append(fwd.Headers,
+		sarama.RecordHeader{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(cause.Error())},
+	)
+	return fwd
+}
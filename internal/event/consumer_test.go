@@ -0,0 +1,97 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return 0, 0, nil
+}
+
+func TestConsumer_process(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := ConsumerConf{Group: "g", RetryTopic: "retry", DeadLetterTopic: "dlq", MaxAttempts: 3}
+	value, err := json.Marshal(testEvent(t, "1"))
+	require.NoError(t, err)
+	msg := &sarama.ConsumerMessage{Topic: "topic", Key: []byte("subject"), Value: value}
+
+	t.Run("Handled", func(t *testing.T) {
+		t.Parallel()
+
+		producer := &fakeProducer{}
+		var handled []Event
+		c := Consumer{conf: conf, producer: producer, handler: HandlerFunc(func(ctx context.Context, e Event) error {
+			handled = append(handled, e)
+			return nil
+		})}
+		require.NoError(t, c.process(ctx, msg))
+		assert.Equal(t, []Event{testEvent(t, "1")}, handled)
+		assert.Empty(t, producer.sent)
+	})
+
+	t.Run("Retried until out of attempts", func(t *testing.T) {
+		t.Parallel()
+
+		producer := &fakeProducer{}
+		c := Consumer{conf: conf, producer: producer, handler: HandlerFunc(func(ctx context.Context, e Event) error {
+			return errors.New("failed")
+		})}
+		require.NoError(t, c.process(ctx, msg))
+		require.Len(t, producer.sent, 1)
+		retry := producer.sent[0]
+		assert.Equal(t, "retry", retry.Topic)
+		assert.Equal(t, sarama.ByteEncoder("subject"), retry.Key)
+		assert.Equal(t, []sarama.RecordHeader{
+			{Key: []byte(HeaderAttempts), Value: []byte("1")},
+			{Key: []byte(HeaderError), Value: []byte("failed")},
+		}, retry.Headers)
+
+		retried := &sarama.ConsumerMessage{Topic: "retry", Value: value, Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderAttempts), Value: []byte("2")},
+		}}
+		require.NoError(t, c.process(ctx, retried))
+		require.Len(t, producer.sent, 2)
+		assert.Equal(t, "dlq", producer.sent[1].Topic)
+	})
+
+	t.Run("Permanent failures skip retries", func(t *testing.T) {
+		t.Parallel()
+
+		producer := &fakeProducer{}
+		c := Consumer{conf: conf, producer: producer, handler: HandlerFunc(func(ctx context.Context, e Event) error {
+			t.Fatal("invalid event handled")
+			return nil
+		})}
+		require.NoError(t, c.process(ctx, &sarama.ConsumerMessage{Value: []byte("{")}))
+		require.Len(t, producer.sent, 1)
+		assert.Equal(t, "dlq", producer.sent[0].Topic)
+	})
+
+	t.Run("Cancelled handling is not forwarded", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		producer := &fakeProducer{}
+		c := Consumer{conf: conf, producer: producer, handler: HandlerFunc(func(ctx context.Context, e Event) error {
+			return ctx.Err()
+		})}
+		require.ErrorIs(t, c.process(ctx, msg), context.Canceled)
+		assert.Empty(t, producer.sent)
+	})
+}
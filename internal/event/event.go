@@ -0,0 +1,85 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// SpecVersion is the CloudEvents version of the envelope.
+const SpecVersion = "1.0"
+
+// Event is a CloudEvents 1.0 envelope in the JSON format, see https://github.com/cloudevents/spec.
+// Type ends with the version of the data schema, e.g. company.created.v1.
+// SubjectVersion, TenantID, ActorID and RequestID are extension attributes, TraceParent and TraceState come from
+// the distributed tracing extension. SubjectVersion orders events of the same subject.
+type Event struct {
+	SpecVersion     string          `json:"specversion" bson:"specversion"`
+	ID              string          `json:"id" bson:"id"`
+	Source          string          `json:"source" bson:"source"`
+	Type            string          `json:"type" bson:"type"`
+	Subject         string          `json:"subject,omitempty" bson:"subject,omitempty"`
+	Time            time.Time       `json:"time" bson:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty" bson:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty" bson:"data,omitempty"`
+	SubjectVersion  int64           `json:"subjectversion,omitempty" bson:"subjectversion,omitempty"`
+	TenantID        string          `json:"tenantid,omitempty" bson:"tenantid,omitempty"`
+	ActorID         string          `json:"actorid,omitempty" bson:"actorid,omitempty"`
+	RequestID       string          `json:"requestid,omitempty" bson:"requestid,omitempty"`
+	TraceParent     string          `json:"traceparent,omitempty" bson:"traceparent,omitempty"`
+	TraceState      string          `json:"tracestate,omitempty" bson:"tracestate,omitempty"`
+}
+
+// SchemaVersion returns the version suffix of the type, e.g. v1.
+func (e Event) SchemaVersion() string {
+	i := strings.LastIndex(e.Type, ".")
+	if i < 0 {
+		return ""
+	}
+	return e.Type[i+1:]
+}
+
+// Trace identifies the request causing events, see https://www.w3.org/TR/trace-context for the trace context.
+type Trace struct {
+	RequestID   string
+	TraceParent string
+	TraceState  string
+}
+
+type traceKey struct{}
+
+func WithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func TraceFrom(ctx context.Context) Trace {
+	t, _ := ctx.Value(traceKey{}).(Trace)
+	return t
+}
+
+// WithTrace returns the event caused by the traced request.
+func (e Event) WithTrace(t Trace) Event {
+	e.RequestID = t.RequestID
+	e.TraceParent = t.TraceParent
+	e.TraceState = t.TraceState
+	return e
+}
+
+// New returns an event with the data encoded as JSON.
+func New(id, source, typ, subject string, t time.Time, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Source:          source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            t,
+		DataContentType: "application/json",
+		Data:            raw,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type FileConf struct {
+	Path string `mapstructure:"path"`
+	// MaxSize in bytes after which the file is rotated, zero disables rotation.
+	MaxSize int64 `mapstructure:"max_size"`
+	// MaxBackups is the number of rotated files kept as path.1, path.2 and so on, path.1 being the newest.
+	MaxBackups int `mapstructure:"max_backups"`
+}
+
+// NewFile returns a sink writing newline delimited JSON to a file rotated by size.
+func NewFile(conf FileConf) (*Writer, error) {
+	if conf.Path == "" {
+		return nil, errors.New("event file path not set")
+	}
+	f, err := openRotating(conf)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+type rotatingFile struct {
+	conf FileConf
+	file *os.File
+	size int64
+}
+
+func openRotating(conf FileConf) (*rotatingFile, error) {
+	r := &rotatingFile{conf: conf}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write rotates the file first if p would not fit in it. Writes are not split, so a single
+// event never spans two files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.conf.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.conf.MaxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside before the new one is opened, the old handle is kept until then,
+// so that a failed rotation leaves the file open for writing and is retried by the next write.
+func (r *rotatingFile) rotate() error {
+	var err error
+	if r.conf.MaxBackups < 1 {
+		err = os.Remove(r.conf.Path)
+	} else {
+		err = r.shift()
+	}
+	if err != nil {
+		return err
+	}
+	old := r.file
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// shift renames path.N-1 to path.N down to path to path.1, the oldest backup is overwritten.
+func (r *rotatingFile) shift() error {
+	for i := r.conf.MaxBackups; i > 0; i-- {
+		from := r.conf.Path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.conf.Path, i-1)
+		}
+		err := os.Rename(from, fmt.Sprintf("%s.%d", r.conf.Path, i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,167 @@
+package event
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms, an empty one disables SASL.
+const (
+	SASLPlain       = "PLAIN"
+	SASLSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+type TLSConf struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CertFile and KeyFile are the client certificate, they are only needed for mutual TLS.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// CAFile verifies brokers instead of the system roots.
+	CAFile string `mapstructure:"ca_file"`
+}
+
+type SASLConf struct {
+	Mechanism string `mapstructure:"mechanism"`
+	User      string `mapstructure:"user"`
+	Password  string `mapstructure:"password"`
+}
+
+// RetryConf of failed sends, zero values keep the defaults of 5 retries 100ms apart.
+type RetryConf struct {
+	Max     int           `mapstructure:"max"`
+	Backoff time.Duration `mapstructure:"backoff"`
+}
+
+// Validate checks the configuration including the TLS files, without connecting to the brokers.
+func (c KafkaConf) Validate() error {
+	if len(c.Servers) < 1 {
+		return errors.New("kafka server not set")
+	}
+	if c.Topic == "" {
+		return errors.New("kafka topic not set")
+	}
+	err := c.Async.Validate()
+	if err != nil {
+		return err
+	}
+	_, err = saramaConfig(c)
+	return err
+}
+
+func saramaConfig(conf KafkaConf) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Producer.Retry.Max = 5
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	partitioner, err := PartitionerOf(conf.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Partitioner = partitioner
+
+	if conf.ClientID != "" {
+		config.ClientID = conf.ClientID
+	}
+	if conf.Version != "" {
+		config.Version, err = sarama.ParseKafkaVersion(conf.Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.Compression != "" {
+		err = config.Producer.Compression.UnmarshalText([]byte(conf.Compression))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.MaxMessageBytes < 0 || conf.Retry.Max < 0 || conf.Retry.Backoff < 0 {
+		return nil, errors.New("kafka limits cannot be negative")
+	}
+	if conf.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = conf.MaxMessageBytes
+	}
+	if conf.Retry.Max > 0 {
+		config.Producer.Retry.Max = conf.Retry.Max
+	}
+	if conf.Retry.Backoff > 0 {
+		config.Producer.Retry.Backoff = conf.Retry.Backoff
+	}
+	if conf.Idempotent {
+		// Idempotence requires a single in-flight request per broker.
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	if conf.TLS.Enabled {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config, err = tlsConfig(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = configureSASL(config, conf.SASL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func tlsConfig(conf TLSConf) (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka client certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if conf.CAFile != "" {
+		ca, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka CA: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("kafka CA: no certificates found")
+		}
+	}
+	return c, nil
+}
+
+func configureSASL(config *sarama.Config, conf SASLConf) error {
+	if conf.Mechanism == "" {
+		return nil
+	}
+	if conf.User == "" || conf.Password == "" {
+		return errors.New("kafka SASL credentials not set")
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = conf.User
+	config.Net.SASL.Password = conf.Password
+	switch conf.Mechanism {
+	case SASLPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClient(scram.SHA256)
+	case SASLSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClient(scram.SHA512)
+	default:
+		return fmt.Errorf("unknown kafka SASL mechanism %q", conf.Mechanism)
+	}
+	return nil
+}
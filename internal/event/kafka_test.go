@@ -0,0 +1,82 @@
+package event
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaConf_Validate(t *testing.T) {
+	t.Parallel()
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	valid := KafkaConf{Servers: []string{"localhost:9092"}, Topic: "topic"}
+	tests := map[string]struct {
+		change func(c *KafkaConf)
+		valid  bool
+	}{
+		"Defaults":            {change: func(c *KafkaConf) {}, valid: true},
+		"No servers":          {change: func(c *KafkaConf) { c.Servers = nil }},
+		"Unknown partitioner": {change: func(c *KafkaConf) { c.Partitioner = "random" }},
+		"Invalid version":     {change: func(c *KafkaConf) { c.Version = "latest" }},
+		"Unknown compression": {change: func(c *KafkaConf) { c.Compression = "brotli" }},
+		"Zstd on old brokers": {change: func(c *KafkaConf) { c.Compression = "zstd"; c.Version = "1.0.0" }},
+		"Zstd":                {change: func(c *KafkaConf) { c.Compression = "zstd"; c.Version = "2.8.0" }, valid: true},
+		"Idempotent":          {change: func(c *KafkaConf) { c.Idempotent = true }, valid: true},
+		"Negative retries":    {change: func(c *KafkaConf) { c.Retry.Max = -1 }},
+		"Missing CA":          {change: func(c *KafkaConf) { c.TLS = TLSConf{Enabled: true, CAFile: "missing.pem"} }},
+		"Invalid CA":          {change: func(c *KafkaConf) { c.TLS = TLSConf{Enabled: true, CAFile: notPEM} }},
+		"TLS with system CA":  {change: func(c *KafkaConf) { c.TLS = TLSConf{Enabled: true} }, valid: true},
+		"Unknown SASL":        {change: func(c *KafkaConf) { c.SASL = SASLConf{Mechanism: "GSSAPI", User: "u", Password: "p"} }},
+		"SASL without secret": {change: func(c *KafkaConf) { c.SASL = SASLConf{Mechanism: SASLSCRAMSHA512, User: "u"} }},
+		"SCRAM":               {change: func(c *KafkaConf) { c.SASL = SASLConf{Mechanism: SASLSCRAMSHA512, User: "u", Password: "p"} }, valid: true},
+		"Async without linger": {change: func(c *KafkaConf) {
+			c.Async = AsyncConf{Enabled: true, QueueSize: 1, OnFull: OnFullBlock, BatchSize: 1}
+		}},
+	}
+	for name, tt := range tests {
+		conf := valid
+		tt.change(&conf)
+		err := conf.Validate()
+		if tt.valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestSaramaConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := saramaConfig(KafkaConf{
+		ClientID:        "crud",
+		Compression:     "lz4",
+		Idempotent:      true,
+		MaxMessageBytes: 2048,
+		Retry:           RetryConf{Max: 3, Backoff: time.Second},
+		SASL:            SASLConf{Mechanism: SASLSCRAMSHA256, User: "u", Password: "p"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "crud", config.ClientID)
+	assert.Equal(t, sarama.CompressionLZ4, config.Producer.Compression)
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+	assert.Equal(t, 2048, config.Producer.MaxMessageBytes)
+	assert.Equal(t, 3, config.Producer.Retry.Max)
+	assert.Equal(t, time.Second, config.Producer.Retry.Backoff)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA256), config.Net.SASL.Mechanism)
+
+	client := config.Net.SASL.SCRAMClientGeneratorFunc()
+	require.NoError(t, client.Begin("u", "p", ""))
+	first, err := client.Step("")
+	require.NoError(t, err)
+	assert.Contains(t, first, "n=u,r=")
+	assert.False(t, client.Done())
+}
@@ -0,0 +1,113 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection = "outbox"
+	leaseCollection  = "outbox_lease"
+	leaseID          = "relay"
+)
+
+// Message is an event stored in the outbox until it is published.
+type Message struct {
+	ID            uuid.UUID  `bson:"_id"`
+	Event         Event      `bson:"event"`
+	CreatedAt     time.Time  `bson:"created_at"`
+	SentAt        *time.Time `bson:"sent_at"`
+	Attempts      int        `bson:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at"`
+	LastError     string     `bson:"last_error,omitempty"`
+}
+
+// Outbox stores events in Mongo, so that they are written in the same transaction as the change they describe.
+type Outbox struct {
+	db *mongo.Database
+}
+
+func NewOutbox(db *mongo.Database) *Outbox {
+	return &Outbox{
+		db: db,
+	}
+}
+
+// Add stores the event. Pass the session context of a transaction to make it part of that transaction.
+func (o *Outbox) Add(ctx context.Context, e Event) error {
+	now := time.Now().UTC()
+	_, err := o.db.Collection(outboxCollection).InsertOne(ctx, Message{
+		ID:            uuid.New(),
+		Event:         e,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	})
+	return err
+}
+
+// Pending returns unsent messages in the order they were added.
+func (o *Outbox) Pending(ctx context.Context, limit int) ([]Message, error) {
+	cur, err := o.db.Collection(outboxCollection).Find(
+		ctx,
+		bson.M{"sent_at": nil},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var messages []Message
+	for cur.Next(ctx) {
+		var m Message
+		err := cur.Decode(&m)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, cur.Err()
+}
+
+func (o *Outbox) MarkSent(ctx context.Context, id uuid.UUID) error {
+	_, err := o.db.Collection(outboxCollection).UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"sent_at": time.Now().UTC()},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// MarkFailed records a failed attempt and postpones the next one.
+func (o *Outbox) MarkFailed(ctx context.Context, id uuid.UUID, next time.Time, cause error) error {
+	_, err := o.db.Collection(outboxCollection).UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"next_attempt_at": next, "last_error": cause.Error()},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// Lease claims the outbox for the owner until the lease ends, a lease is extended by its owner and taken over
+// by another one only once it has ended. It tells whether the owner holds the lease.
+func (o *Outbox) Lease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	err := o.db.Collection(leaseCollection).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": leaseID, "$or": bson.A{bson.M{"owner": owner}, bson.M{"locked_until": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"owner": owner, "locked_until": now.Add(ttl)}},
+		options.FindOneAndUpdate().SetUpsert(true),
+	).Err()
+	// The lease of another owner makes the upsert insert a second lease, which is refused.
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+	return true, nil
+}
@@ -1,28 +1,80 @@
 package event
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+
 	"github.com/Shopify/sarama"
-	"github.com/rs/zerolog/log"
+)
+
+// Headers of Kafka messages, so that consumers can route events without decoding them.
+const (
+	HeaderType          = "event_type"
+	HeaderSchemaVersion = "schema_version"
+	HeaderTenantID      = "tenant_id"
+	HeaderActorID       = "actor_id"
+	HeaderRequestID     = "request_id"
+	HeaderTraceParent   = "traceparent"
+	HeaderTraceState    = "tracestate"
+)
+
+// Partitioners choose the partition by the message key, so that events of a company stay ordered.
+// PartitionerHash is the default, PartitionerReference is compatible with the Java client.
+const (
+	PartitionerHash      = "hash"
+	PartitionerReference = "reference"
+	PartitionerCRC32     = "crc32"
 )
 
 type KafkaConf struct {
-	Servers []string `mapstructure:"servers"`
-	Topic   string   `mapstructure:"topic"`
+	Servers     []string `mapstructure:"servers"`
+	Topic       string   `mapstructure:"topic"`
+	Partitioner string   `mapstructure:"partitioner"`
+	ClientID    string   `mapstructure:"client_id"`
+	// Version of the brokers, e.g. 2.8.0. Defaults to the oldest version supported by sarama.
+	Version string   `mapstructure:"version"`
+	TLS     TLSConf  `mapstructure:"tls"`
+	SASL    SASLConf `mapstructure:"sasl"`
+	// Compression is one of none (default), gzip, snappy, lz4 and zstd.
+	Compression string `mapstructure:"compression"`
+	// Idempotent producers never duplicate messages on retries.
+	Idempotent      bool      `mapstructure:"idempotent"`
+	MaxMessageBytes int       `mapstructure:"max_message_bytes"`
+	Retry           RetryConf `mapstructure:"retry"`
+	// Async selects AsyncProducer instead of Producer.
+	Async AsyncConf `mapstructure:"async"`
 }
 
-func NewProducer(conf KafkaConf) Producer {
-	p := Producer{}
-	config := sarama.NewConfig()
-	config.Producer.Retry.Max = 5
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Return.Successes = true
+// NewProducer returns a sink publishing to Kafka, it fails when no broker is reachable.
+func NewProducer(conf KafkaConf) (*Producer, error) {
+	config, err := saramaConfig(conf)
+	if err != nil {
+		return nil, err
+	}
 	prd, err := sarama.NewSyncProducer(conf.Servers, config)
 	if err != nil {
-		log.Fatal().Msg(err.Error())
+		return nil, err
+	}
+	return &Producer{
+		topic:    conf.Topic,
+		producer: prd,
+	}, nil
+}
+
+// PartitionerOf returns the partitioner of the given name, an empty name stands for PartitionerHash.
+func PartitionerOf(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case "", PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+	case PartitionerReference:
+		return sarama.NewReferenceHashPartitioner, nil
+	case PartitionerCRC32:
+		return sarama.NewCustomHashPartitioner(func() hash.Hash32 { return crc32.NewIEEE() }), nil
+	default:
+		return nil, fmt.Errorf("unknown partitioner %q", name)
 	}
-	p.producer = prd
-	p.topic = conf.Topic
-	return p
 }
 
 type Producer struct {
@@ -30,15 +82,47 @@ type Producer struct {
 	producer sarama.SyncProducer
 }
 
-func (p Producer) Produce(m string) error {
-	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Value: sarama.StringEncoder(m),
+// Produce sends the event keyed by its subject.
+func (p *Producer) Produce(e Event) error {
+	msg, err := messageOf(p.topic, e)
+	if err != nil {
+		return err
 	}
 
-	_, _, err := p.producer.SendMessage(msg)
+	_, _, err = p.producer.SendMessage(msg)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
+
+func messageOf(topic string, e Event) (*sarama.ProducerMessage, error) {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(e.Subject),
+		Value: sarama.ByteEncoder(value),
+	}
+	headers := []struct{ key, value string }{
+		{HeaderType, e.Type},
+		{HeaderSchemaVersion, e.SchemaVersion()},
+		{HeaderTenantID, e.TenantID},
+		{HeaderActorID, e.ActorID},
+		{HeaderRequestID, e.RequestID},
+		{HeaderTraceParent, e.TraceParent},
+		{HeaderTraceState, e.TraceState},
+	}
+	for _, h := range headers {
+		if h.value != "" {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(h.key), Value: []byte(h.value)})
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,51 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageOf(t *testing.T) {
+	t.Parallel()
+
+	e, err := New("id", "/v1/companies", "company.created.v1", "subject", time.Now().UTC(), map[string]string{"a": "b"})
+	require.NoError(t, err)
+	e = e.WithTrace(Trace{RequestID: "request", TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"})
+	e.ActorID = "actor"
+	e.TenantID = "tenant"
+
+	msg, err := messageOf("topic", e)
+	require.NoError(t, err)
+	assert.Equal(t, sarama.StringEncoder("subject"), msg.Key)
+	assert.Equal(t, []sarama.RecordHeader{
+		{Key: []byte(HeaderType), Value: []byte("company.created.v1")},
+		{Key: []byte(HeaderSchemaVersion), Value: []byte("v1")},
+		{Key: []byte(HeaderTenantID), Value: []byte("tenant")},
+		{Key: []byte(HeaderActorID), Value: []byte("actor")},
+		{Key: []byte(HeaderRequestID), Value: []byte("request")},
+		{Key: []byte(HeaderTraceParent), Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")},
+	}, msg.Headers)
+
+	value, err := msg.Value.Encode()
+	require.NoError(t, err)
+	var decoded Event
+	require.NoError(t, json.Unmarshal(value, &decoded))
+	assert.Equal(t, e, decoded)
+}
+
+func TestPartitionerOf(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"", PartitionerHash, PartitionerReference, PartitionerCRC32} {
+		p, err := PartitionerOf(name)
+		require.NoError(t, err)
+		assert.True(t, p("topic").RequiresConsistency(), name)
+	}
+	_, err := PartitionerOf("random")
+	require.Error(t, err)
+}
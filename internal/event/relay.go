@@ -0,0 +1,165 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	relayBatch      = 100
+	relayInterval   = time.Second
+	relayMaxBackoff = 5 * time.Minute
+	// relayLease is how long a relay claims the outbox, it is extended before every batch.
+	relayLease = 30 * time.Second
+)
+
+// Relay publishes outbox messages. Delivery is at-least-once: a message is marked sent only after
+// it has been published, so it is published again if the process dies in between.
+// Messages are published one by one in the order they were added, a failed one blocks the rest
+// until it is retried, so that consumers never see changes out of order.
+// Asynchronous sinks are not waited for, so a failed message may be retried after the following ones.
+// Relays of all the instances share the outbox, only the one holding its lease publishes, so that
+// messages are neither published twice nor reordered by relays racing each other.
+type Relay struct {
+	outbox *Outbox
+	sink   Sink
+	owner  string
+
+	mu       sync.Mutex
+	inflight map[uuid.UUID]struct{}
+}
+
+func NewRelay(outbox *Outbox, sink Sink) *Relay {
+	return &Relay{
+		outbox:   outbox,
+		sink:     sink,
+		owner:    uuid.NewString(),
+		inflight: make(map[uuid.UUID]struct{}),
+	}
+}
+
+// Run relays messages until the context is done. Full batches are followed by the next one immediately,
+// otherwise the relay waits for the next tick.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+	for {
+		more, err := r.relay(ctx)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to relay outbox.")
+		}
+		if more && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes a batch of messages if the relay holds the lease of the outbox.
+// It tells whether the whole batch was published, in which case more messages may be pending.
+func (r *Relay) relay(ctx context.Context) (bool, error) {
+	leased, err := r.outbox.Lease(ctx, r.owner, relayLease)
+	if err != nil || !leased {
+		return false, err
+	}
+	messages, err := r.outbox.Pending(ctx, relayBatch)
+	if err != nil {
+		return false, err
+	}
+	async, isAsync := r.sink.(AsyncSink)
+	published := 0
+	for _, m := range messages {
+		if r.isInflight(m.ID) {
+			continue
+		}
+		if time.Now().Before(m.NextAttemptAt) {
+			return false, nil
+		}
+		if isAsync {
+			err := r.produceAsync(ctx, async, m)
+			if errors.Is(err, ErrQueueFull) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			published++
+			continue
+		}
+		err := r.sink.Produce(m.Event)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("id", m.Event.ID).Int("attempts", m.Attempts+1).Msg("Failed to publish event.")
+			return false, r.outbox.MarkFailed(ctx, m.ID, time.Now().Add(backoff(m.Attempts)), err)
+		}
+		err = r.outbox.MarkSent(ctx, m.ID)
+		if err != nil {
+			return false, err
+		}
+		published++
+	}
+	return published == relayBatch, nil
+}
+
+// produceAsync queues the message, it is marked once delivered. Marking must not be cancelled
+// together with the relay, as the sink flushes queued messages on close.
+func (r *Relay) produceAsync(ctx context.Context, sink AsyncSink, m Message) error {
+	r.setInflight(m.ID, true)
+	markCtx := log.Ctx(ctx).WithContext(context.Background())
+	err := sink.ProduceAsync(m.Event, func(err error) {
+		defer r.setInflight(m.ID, false)
+		if err == nil {
+			err = r.outbox.MarkSent(markCtx, m.ID)
+		} else {
+			log.Ctx(ctx).Warn().Err(err).Str("id", m.Event.ID).Int("attempts", m.Attempts+1).Msg("Failed to publish event.")
+			err = r.outbox.MarkFailed(markCtx, m.ID, time.Now().Add(backoff(m.Attempts)), err)
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("id", m.Event.ID).Msg("Failed to mark outbox message.")
+		}
+	})
+	if err != nil {
+		r.setInflight(m.ID, false)
+	}
+	return err
+}
+
+func (r *Relay) isInflight(id uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.inflight[id]
+	return ok
+}
+
+func (r *Relay) setInflight(id uuid.UUID, inflight bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if inflight {
+		r.inflight[id] = struct{}{}
+	} else {
+		delete(r.inflight, id)
+	}
+}
+
+// backoff doubles the delay after every failed attempt, starting from the relay interval.
+func backoff(attempts int) time.Duration {
+	d := relayInterval
+	for i := 0; i < attempts && d < relayMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > relayMaxBackoff {
+		return relayMaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,205 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/internal/platform/mongo/double"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	double.Purge()
+	os.Exit(code)
+}
+
+// failingSink fails the first fail events it is given, the rest are recorded.
+type failingSink struct {
+	Recorder
+	mu   sync.Mutex
+	fail int
+}
+
+func (s *failingSink) Produce(e Event) error {
+	s.mu.Lock()
+	failed := s.fail > 0
+	s.fail--
+	s.mu.Unlock()
+	if failed {
+		return errors.New("broker unavailable")
+	}
+	return s.Recorder.Produce(e)
+}
+
+// asyncSink acknowledges events in the background, failing the first fail of them.
+type asyncSink struct {
+	failingSink
+}
+
+func (s *asyncSink) ProduceAsync(e Event, done func(error)) error {
+	go func() {
+		done(s.Produce(e))
+	}()
+	return nil
+}
+
+func addEvents(t *testing.T, outbox *Outbox, n int) []Event {
+	t.Helper()
+
+	var events []Event
+	for i := 0; i < n; i++ {
+		e := testEvent(t, strconv.Itoa(i))
+		require.NoError(t, outbox.Add(context.Background(), e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestRelay(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("Published messages are marked sent", func(t *testing.T) {
+		t.Parallel()
+
+		db := double.NewDocker()
+		outbox := NewOutbox(db)
+		events := addEvents(t, outbox, 3)
+		sink := &Recorder{}
+
+		more, err := NewRelay(outbox, sink).relay(ctx)
+		require.NoError(t, err)
+		assert.False(t, more)
+		assert.Equal(t, events, sink.Events())
+
+		pending, err := outbox.Pending(ctx, relayBatch)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+		var sent []Message
+		cur, err := db.Collection(outboxCollection).Find(ctx, bson.M{})
+		require.NoError(t, err)
+		require.NoError(t, cur.All(ctx, &sent))
+		require.Len(t, sent, 3)
+		for _, m := range sent {
+			assert.NotNil(t, m.SentAt)
+			assert.Equal(t, 1, m.Attempts)
+		}
+	})
+
+	t.Run("Failed message backs off and blocks the following ones", func(t *testing.T) {
+		t.Parallel()
+
+		db := double.NewDocker()
+		outbox := NewOutbox(db)
+		events := addEvents(t, outbox, 3)
+		sink := &failingSink{fail: 1}
+		relay := NewRelay(outbox, sink)
+
+		_, err := relay.relay(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, sink.Events())
+		pending, err := outbox.Pending(ctx, relayBatch)
+		require.NoError(t, err)
+		require.Len(t, pending, 3)
+		head := pending[0]
+		assert.Equal(t, events[0], head.Event)
+		assert.Equal(t, 1, head.Attempts)
+		assert.Equal(t, "broker unavailable", head.LastError)
+		assert.WithinDuration(t, time.Now().Add(backoff(0)), head.NextAttemptAt, time.Second)
+
+		// The head is not due yet, the following messages wait for it.
+		_, err = relay.relay(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, sink.Events())
+
+		_, err = db.Collection(outboxCollection).UpdateByID(ctx, head.ID, bson.M{"$set": bson.M{"next_attempt_at": time.Now().Add(-time.Second)}})
+		require.NoError(t, err)
+		_, err = relay.relay(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, events, sink.Events())
+	})
+
+	t.Run("Asynchronous sink marks messages once acknowledged", func(t *testing.T) {
+		t.Parallel()
+
+		db := double.NewDocker()
+		outbox := NewOutbox(db)
+		events := addEvents(t, outbox, 2)
+		sink := &asyncSink{failingSink{fail: 1}}
+		relay := NewRelay(outbox, sink)
+
+		_, err := relay.relay(ctx)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			pending, err := outbox.Pending(ctx, relayBatch)
+			return err == nil && len(pending) == 1 && pending[0].Attempts == 1 && !relay.isInflight(pending[0].ID)
+		}, 5*time.Second, 10*time.Millisecond)
+		pending, err := outbox.Pending(ctx, relayBatch)
+		require.NoError(t, err)
+		assert.Equal(t, events[0], pending[0].Event)
+		assert.Equal(t, events[1:], sink.Events())
+	})
+
+	t.Run("Full batches are drained without waiting", func(t *testing.T) {
+		t.Parallel()
+
+		db := double.NewDocker()
+		outbox := NewOutbox(db)
+		events := addEvents(t, outbox, relayBatch+1)
+		sink := &Recorder{}
+		relay := NewRelay(outbox, sink)
+
+		more, err := relay.relay(ctx)
+		require.NoError(t, err)
+		assert.True(t, more)
+		assert.Len(t, sink.Events(), relayBatch)
+		more, err = relay.relay(ctx)
+		require.NoError(t, err)
+		assert.False(t, more)
+		assert.Equal(t, events, sink.Events())
+
+		// Run publishes the next batch right away instead of waiting for the next tick.
+		events = append(events, addEvents(t, outbox, relayBatch+1)...)
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go relay.Run(runCtx)
+		assert.Eventually(t, func() bool {
+			return len(sink.Events()) == len(events)
+		}, relayInterval/2, 10*time.Millisecond)
+	})
+
+	t.Run("Only the relay holding the lease publishes", func(t *testing.T) {
+		t.Parallel()
+
+		db := double.NewDocker()
+		outbox := NewOutbox(db)
+		addEvents(t, outbox, 1)
+		first, second := &Recorder{}, &Recorder{}
+
+		_, err := NewRelay(outbox, first).relay(ctx)
+		require.NoError(t, err)
+		addEvents(t, outbox, 1)
+		_, err = NewRelay(outbox, second).relay(ctx)
+		require.NoError(t, err)
+		assert.Len(t, first.Events(), 1)
+		assert.Empty(t, second.Events())
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, relayInterval, backoff(0))
+	assert.Equal(t, 2*relayInterval, backoff(1))
+	assert.Equal(t, 8*relayInterval, backoff(3))
+	assert.Equal(t, relayMaxBackoff, backoff(20))
+}
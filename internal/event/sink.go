@@ -0,0 +1,136 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sinks selectable in the configuration.
+const (
+	SinkKafka  = "kafka"
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkNop    = "none"
+)
+
+// Sink publishes events.
+type Sink interface {
+	Produce(e Event) error
+	// Close flushes buffered events and releases the sink.
+	Close() error
+}
+
+type Conf struct {
+	Sink  string
+	Kafka KafkaConf
+	File  FileConf
+}
+
+// NewSink returns the sink selected by the configuration, an empty one stands for SinkKafka.
+func NewSink(conf Conf) (Sink, error) {
+	switch conf.Sink {
+	case "", SinkKafka:
+		if conf.Kafka.Async.Enabled {
+			return NewAsyncProducer(conf.Kafka)
+		}
+		return NewProducer(conf.Kafka)
+	case SinkStdout:
+		return NewWriter(os.Stdout), nil
+	case SinkFile:
+		return NewFile(conf.File)
+	case SinkNop:
+		return Nop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", conf.Sink)
+	}
+}
+
+// Writer writes events as newline delimited JSON.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	w   io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		enc: json.NewEncoder(w),
+		w:   w,
+	}
+}
+
+func (w *Writer) Produce(e Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.enc.Encode(e)
+}
+
+// Close closes the underlying writer unless it is stdout or stderr.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c, ok := w.w.(io.Closer)
+	if !ok || w.w == os.Stdout || w.w == os.Stderr {
+		return nil
+	}
+	return c.Close()
+}
+
+// Overloader is implemented by sinks which cannot keep up with events temporarily.
+type Overloader interface {
+	Overloaded() bool
+}
+
+// OverloaderOf returns the overloader of the sink, sinks which are never overloaded are returned as Nop.
+func OverloaderOf(s Sink) Overloader {
+	if o, ok := s.(Overloader); ok {
+		return o
+	}
+	return Nop{}
+}
+
+// Recorder keeps events in memory, it is meant for tests.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *Recorder) Produce(e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	return nil
+}
+
+// Events returns the produced events in order.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Event(nil), r.events...)
+}
+
+// Nop discards events.
+type Nop struct{}
+
+func (Nop) Produce(Event) error {
+	return nil
+}
+
+func (Nop) Close() error {
+	return nil
+}
+
+func (Nop) Overloaded() bool {
+	return false
+}
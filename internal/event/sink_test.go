@@ -0,0 +1,124 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	first := testEvent(t, "1")
+	second := testEvent(t, "2")
+	require.NoError(t, w.Produce(first))
+	require.NoError(t, w.Produce(second))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, []Event{first, second}, decodeLines(t, buf.Bytes()))
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	var r Recorder
+	e := testEvent(t, "1")
+	require.NoError(t, r.Produce(e))
+	assert.Equal(t, []Event{e}, r.Events())
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line, err := json.Marshal(testEvent(t, "1"))
+	require.NoError(t, err)
+	w, err := NewFile(FileConf{Path: path, MaxSize: int64(len(line)+1) * 2, MaxBackups: 1})
+	require.NoError(t, err)
+
+	var events []Event
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		e := testEvent(t, id)
+		events = append(events, e)
+		require.NoError(t, w.Produce(e))
+	}
+	require.NoError(t, w.Close())
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, events[4:], decodeLines(t, current))
+	backup, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, events[2:4], decodeLines(t, backup))
+	_, err = os.Stat(path + ".2")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFile_FailedRotation(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	f, err := openRotating(FileConf{Path: path, MaxSize: 4, MaxBackups: 1})
+	require.NoError(t, err)
+
+	// A directory in place of the backup makes the rotation fail, the file stays open.
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755))
+	_, err = f.Write([]byte("one\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("two\n"))
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll(path+".1"))
+	_, err = f.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(current))
+	backup, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(backup))
+}
+
+func TestNewSink(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSink(Conf{Sink: SinkNop})
+	require.NoError(t, err)
+	assert.Equal(t, Nop{}, s)
+	_, err = NewSink(Conf{Sink: SinkFile})
+	require.Error(t, err)
+	_, err = NewSink(Conf{Sink: "unknown"})
+	require.Error(t, err)
+}
+
+func testEvent(t *testing.T, id string) Event {
+	t.Helper()
+
+	e, err := New(id, "/test", "test.created.v1", "subject", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"id": id})
+	require.NoError(t, err)
+	return e
+}
+
+func decodeLines(t *testing.T, data []byte) []Event {
+	t.Helper()
+
+	var events []Event
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,26 @@
+// Package mongo holds helpers shared by the Mongo storages of the services.
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// StorageError marks errors caused by Mongo being unreachable with the unavailable error of the storage,
+// so that callers can retry later. Other errors are returned as they are.
+func StorageError(err, unavailable error) error {
+	var selection topology.ServerSelectionError
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.As(err, &selection) {
+		return fmt.Errorf("%w: %v", unavailable, err)
+	}
+	return err
+}
+
+// Now is truncated to milliseconds which is the precision of Mongo dates.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -1,3 +1,12 @@
+try {
+    rs.status()
+} catch (e) {
+    rs.initiate({_id: "rs0", members: [{_id: 0, host: "mongo:27017"}]})
+}
+while (!db.isMaster().ismaster) {
+    sleep(100)
+}
+
 db = db.getSiblingDB("companies")
 if (db.getUser("tuser") === null) {
     db.createUser({
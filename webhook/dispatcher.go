@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	platform "github.com/a-romancev/crud_task/internal/platform/mongo"
+	"github.com/google/uuid"
+)
+
+// Dispatcher consumes company events and queues a delivery for every subscription of their tenant accepting them.
+// Deliveries are unique per subscription and event, so redelivered events are queued once.
+// Events of other types, such as replayed snapshots, are not delivered.
+type Dispatcher struct {
+	store *Mongo
+}
+
+func NewDispatcher(store *Mongo) *Dispatcher {
+	return &Dispatcher{
+		store: store,
+	}
+}
+
+func (d *Dispatcher) Handle(ctx context.Context, e event.Event) error {
+	if !contains(EventTypes, e.Type) {
+		return nil
+	}
+	if e.TenantID == "" {
+		return event.Permanent(company.ErrNoTenant)
+	}
+	ctx = company.WithTenant(ctx, e.TenantID)
+	subs, err := d.store.Matching(ctx, e.Type)
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		t := platform.Now()
+		err := d.store.Enqueue(ctx, Delivery{
+			ID:             uuid.New(),
+			SubscriptionID: s.ID,
+			Event:          e,
+			Status:         StatusPending,
+			Attempts:       []Attempt{},
+			NextAttemptAt:  t,
+			CreatedAt:      t,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	platform "github.com/a-romancev/crud_task/internal/platform/mongo"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collection         = "webhooks"
+	deliveryCollection = "webhook_deliveries"
+)
+
+// Mongo stores subscriptions and deliveries of the context tenant, see company.WithTenant.
+// ClaimAllTenants is the only method working across tenants.
+type Mongo struct {
+	db *mongo.Database
+}
+
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{
+		db: db,
+	}
+}
+
+// Create stores a new active subscription.
+func (m Mongo) Create(ctx context.Context, s Subscription) (Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+	s.TenantID = tenant
+	s.Active = true
+	s.Failures = 0
+	s.DisabledAt = nil
+	s.CreatedAt = platform.Now()
+	s.UpdatedAt = s.CreatedAt
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	_, err = m.db.Collection(collection).InsertOne(ctx, s)
+	if err != nil {
+		return Subscription{}, storageError(err)
+	}
+	return s, nil
+}
+
+// Fetch returns subscriptions in the order they were created.
+func (m Mongo) Fetch(ctx context.Context, lookup Lookup) ([]Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	if lookup.Limit > 0 {
+		opts.SetLimit(int64(lookup.Limit))
+	}
+	if lookup.Offset > 0 {
+		opts.SetSkip(int64(lookup.Offset))
+	}
+	cur, err := m.db.Collection(collection).Find(ctx, filterOf(tenant, lookup), opts)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var subs []Subscription
+	err = cur.All(ctx, &subs)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return subs, nil
+}
+
+// Count returns the number of subscriptions matching the lookup. Paging fields are ignored.
+func (m Mongo) Count(ctx context.Context, lookup Lookup) (int64, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.db.Collection(collection).CountDocuments(ctx, filterOf(tenant, lookup))
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return n, nil
+}
+
+func (m Mongo) FetchOne(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+	var s Subscription
+	err = m.db.Collection(collection).FindOne(ctx, bson.M{"_id": id, "tenant_id": tenant}).Decode(&s)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return Subscription{}, ErrNotFound
+	case err != nil:
+		return Subscription{}, storageError(err)
+	}
+	return s, nil
+}
+
+// Update replaces the fields of the subscription which can be patched.
+func (m Mongo) Update(ctx context.Context, s Subscription) (Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+	s.TenantID = tenant
+	s.UpdatedAt = platform.Now()
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	res, err := m.db.Collection(collection).UpdateOne(ctx, bson.M{"_id": s.ID, "tenant_id": tenant}, bson.M{"$set": bson.M{
+		"url":         s.URL,
+		"event_types": s.EventTypes,
+		"secret":      s.Secret,
+		"active":      s.Active,
+		"failures":    s.Failures,
+		"disabled_at": s.DisabledAt,
+		"updated_at":  s.UpdatedAt,
+	}})
+	switch {
+	case err != nil:
+		return Subscription{}, storageError(err)
+	case res.MatchedCount == 0:
+		return Subscription{}, ErrNotFound
+	}
+	if !s.Active {
+		return s, m.cancelPending(ctx, s.ID)
+	}
+	return s, nil
+}
+
+// Delete removes the subscription and cancels its pending deliveries.
+func (m Mongo) Delete(ctx context.Context, id uuid.UUID) error {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := m.db.Collection(collection).DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenant})
+	switch {
+	case err != nil:
+		return storageError(err)
+	case res.DeletedCount == 0:
+		return ErrNotFound
+	}
+	return m.cancelPending(ctx, id)
+}
+
+// Matching returns active subscriptions accepting events of the type.
+func (m Mongo) Matching(ctx context.Context, eventType string) ([]Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := m.db.Collection(collection).Find(ctx, bson.M{
+		"tenant_id": tenant,
+		"active":    true,
+		"$or": bson.A{
+			bson.M{"event_types": eventType},
+			bson.M{"event_types": bson.M{"$size": 0}},
+		},
+	})
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var subs []Subscription
+	err = cur.All(ctx, &subs)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return subs, nil
+}
+
+// Succeeded resets the consecutive failures of the subscription.
+func (m Mongo) Succeeded(ctx context.Context, id uuid.UUID) error {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.Collection(collection).UpdateOne(ctx, bson.M{"_id": id, "tenant_id": tenant}, bson.M{"$set": bson.M{"failures": 0}})
+	return storageError(err)
+}
+
+// Failed counts a failed attempt of the subscription and disables it after disableAfter consecutive ones.
+// It tells whether the subscription has been disabled by this failure.
+func (m Mongo) Failed(ctx context.Context, id uuid.UUID, disableAfter int) (bool, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return false, err
+	}
+	var s Subscription
+	err = m.db.Collection(collection).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "tenant_id": tenant},
+		bson.M{"$inc": bson.M{"failures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&s)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return false, nil
+	case err != nil:
+		return false, storageError(err)
+	case disableAfter < 1 || s.Failures < disableAfter:
+		return false, nil
+	}
+
+	disabledAt := platform.Now()
+	res, err := m.db.Collection(collection).UpdateOne(
+		ctx,
+		bson.M{"_id": id, "tenant_id": tenant, "active": true},
+		bson.M{"$set": bson.M{"active": false, "disabled_at": disabledAt, "updated_at": disabledAt}},
+	)
+	if err != nil {
+		return false, storageError(err)
+	}
+	if res.ModifiedCount == 0 {
+		return false, nil
+	}
+	return true, m.cancelPending(ctx, id)
+}
+
+// Enqueue stores a pending delivery. An event is delivered to a subscription only once,
+// so enqueueing it again is a no-op.
+func (m Mongo) Enqueue(ctx context.Context, d Delivery) error {
+	_, err := m.db.Collection(deliveryCollection).InsertOne(ctx, d)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return storageError(err)
+}
+
+// ClaimAllTenants locks the most overdue pending delivery of any tenant whose next attempt is not after t
+// for the lease, so that workers running side by side never send the same delivery. A delivery whose worker
+// died is claimed again once the lease has ended. ErrNotFound is returned when no delivery is due.
+// Deliveries of all tenants share the workers, the tenant of the claimed one scopes the rest of its handling.
+func (m Mongo) ClaimAllTenants(ctx context.Context, t time.Time, lease time.Duration) (Delivery, error) {
+	var d Delivery
+	err := m.db.Collection(deliveryCollection).FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"status":          StatusPending,
+			"next_attempt_at": bson.M{"$lte": t},
+			"$or":             bson.A{bson.M{"locked_until": nil}, bson.M{"locked_until": bson.M{"$lte": t}}},
+		},
+		bson.M{"$set": bson.M{"locked_until": t.Add(lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&d)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return Delivery{}, ErrNotFound
+	case err != nil:
+		return Delivery{}, storageError(err)
+	}
+	return d, nil
+}
+
+// Record stores the attempt of the delivery with its resulting status and releases its claim,
+// attempt is nil for cancelled deliveries.
+func (m Mongo) Record(ctx context.Context, id uuid.UUID, attempt *Attempt, status string, next time.Time) error {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"status": status, "next_attempt_at": next}, "$unset": bson.M{"locked_until": ""}}
+	if attempt != nil {
+		update["$push"] = bson.M{"attempts": attempt}
+	}
+	_, err = m.db.Collection(deliveryCollection).UpdateOne(ctx, bson.M{"_id": id, "event.tenantid": tenant}, update)
+	return storageError(err)
+}
+
+// Deliveries returns deliveries of the subscription, newest first.
+func (m Mongo) Deliveries(ctx context.Context, lookup Lookup) ([]Delivery, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}})
+	if lookup.Limit > 0 {
+		opts.SetLimit(int64(lookup.Limit))
+	}
+	if lookup.Offset > 0 {
+		opts.SetSkip(int64(lookup.Offset))
+	}
+	cur, err := m.db.Collection(deliveryCollection).Find(ctx, deliveriesFilter(tenant, lookup), opts)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var deliveries []Delivery
+	err = cur.All(ctx, &deliveries)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return deliveries, nil
+}
+
+// CountDeliveries returns the number of deliveries of the subscription. Paging fields are ignored.
+func (m Mongo) CountDeliveries(ctx context.Context, lookup Lookup) (int64, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.db.Collection(deliveryCollection).CountDocuments(ctx, deliveriesFilter(tenant, lookup))
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return n, nil
+}
+
+func (m Mongo) cancelPending(ctx context.Context, id uuid.UUID) error {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.Collection(deliveryCollection).UpdateMany(
+		ctx,
+		bson.M{"subscription_id": id, "event.tenantid": tenant, "status": StatusPending},
+		bson.M{"$set": bson.M{"status": StatusCancelled}},
+	)
+	return storageError(err)
+}
+
+func filterOf(tenant string, lookup Lookup) bson.M {
+	filter := bson.M{"tenant_id": tenant}
+	if lookup.ID != uuid.Nil {
+		filter["_id"] = lookup.ID
+	}
+	return filter
+}
+
+// deliveriesFilter matches deliveries of the subscription, their tenant is the one of the delivered event.
+func deliveriesFilter(tenant string, lookup Lookup) bson.M {
+	return bson.M{"subscription_id": lookup.ID, "event.tenantid": tenant}
+}
+
+// storageError marks errors caused by Mongo being unreachable with ErrUnavailable.
+func storageError(err error) error {
+	return platform.StorageError(err, ErrUnavailable)
+}
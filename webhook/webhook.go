@@ -0,0 +1,180 @@
+// Package webhook delivers company events to HTTP callbacks of subscribers.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound    = errors.New("webhook not found")
+	ErrUnavailable = errors.New("webhook storage unavailable")
+)
+
+// CodeInvalid is the violation code of malformed values, other codes are shared with companies.
+const CodeInvalid = "invalid_value"
+
+// EventTypes a subscription can be filtered by.
+var EventTypes = []string{company.EventCreated, company.EventUpdated, company.EventDeleted, company.EventRestored}
+
+const minSecretLength = 16
+
+// Subscription is a webhook receiving company events. Secret signs deliveries, it is never returned by the API.
+type Subscription struct {
+	ID uuid.UUID `json:"id" bson:"_id"`
+	// TenantID is set by the storage from the context, see company.WithTenant.
+	TenantID string `json:"tenant_id" bson:"tenant_id"`
+	URL      string `json:"url" bson:"url"`
+	// EventTypes filters delivered events, empty means all of them.
+	EventTypes []string `json:"event_types" bson:"event_types"`
+	Secret     string   `json:"secret,omitempty" bson:"secret"`
+	Active     bool     `json:"active" bson:"active"`
+	// Failures counts consecutive failed attempts, the subscription is disabled once there are too many.
+	Failures   int        `json:"failures" bson:"failures"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty" bson:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+func (s Subscription) Validate() error {
+	var verr company.ValidationError
+	add := func(field, code, msg string) {
+		verr.Violations = append(verr.Violations, company.Violation{Field: field, Code: code, Message: msg})
+	}
+	if s.ID == uuid.Nil {
+		add("id", company.CodeRequired, "id should not be empty")
+	}
+	if s.URL == "" {
+		add("url", company.CodeRequired, "url cannot be empty")
+	} else if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("url", CodeInvalid, "url must be an absolute http or https URL")
+	}
+	if len(s.Secret) < minSecretLength {
+		add("secret", CodeInvalid, "secret must be at least 16 characters")
+	}
+	for _, t := range s.EventTypes {
+		if !contains(EventTypes, t) {
+			add("event_types", company.CodeUnknown, "unknown event type "+t)
+		}
+	}
+	if len(verr.Violations) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+// Redacted returns the subscription without its secret.
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Accepts tells whether the subscription is interested in events of the type.
+func (s Subscription) Accepts(eventType string) bool {
+	return len(s.EventTypes) == 0 || contains(s.EventTypes, eventType)
+}
+
+// Patch changes the given fields of a subscription. Activating a subscription resets its failures.
+type Patch struct {
+	URL        *string   `json:"url"`
+	EventTypes *[]string `json:"event_types"`
+	Secret     *string   `json:"secret"`
+	Active     *bool     `json:"active"`
+}
+
+func (p Patch) Apply(s Subscription) Subscription {
+	if p.URL != nil {
+		s.URL = *p.URL
+	}
+	if p.EventTypes != nil {
+		s.EventTypes = *p.EventTypes
+	}
+	if p.Secret != nil {
+		s.Secret = *p.Secret
+	}
+	if p.Active != nil {
+		s.Active = *p.Active
+		if s.Active {
+			s.Failures = 0
+			s.DisabledAt = nil
+		}
+	}
+	return s
+}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusFailed deliveries ran out of attempts.
+	StatusFailed = "failed"
+	// StatusCancelled deliveries belong to deleted or disabled subscriptions.
+	StatusCancelled = "cancelled"
+)
+
+// Delivery is an event to be sent to a subscription, with the record of every attempt.
+type Delivery struct {
+	ID             uuid.UUID   `json:"id" bson:"_id"`
+	SubscriptionID uuid.UUID   `json:"subscription_id" bson:"subscription_id"`
+	Event          event.Event `json:"event" bson:"event"`
+	Status         string      `json:"status" bson:"status"`
+	Attempts       []Attempt   `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time   `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt      time.Time   `json:"created_at" bson:"created_at"`
+	// LockedUntil is set while a worker sends the delivery, other workers skip it until then.
+	LockedUntil *time.Time `json:"-" bson:"locked_until,omitempty"`
+}
+
+// Attempt of a delivery. StatusCode is zero when no response was received.
+type Attempt struct {
+	Time       time.Time     `json:"time" bson:"time"`
+	StatusCode int           `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty" bson:"error,omitempty"`
+	Duration   time.Duration `json:"duration" bson:"duration"`
+}
+
+func (a Attempt) OK() bool {
+	return a.Error == ""
+}
+
+type Lookup struct {
+	ID uuid.UUID `json:"id"`
+	// Limit of zero means no limit.
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// Signature headers of deliveries. The signature is the hex encoded HMAC-SHA256 of the timestamp,
+// a dot and the body, keyed by the subscription secret, e.g. t=1667260800,v1=5257a869...
+// Receivers should reject old timestamps to prevent replays.
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+// Sign returns the signature header value of the body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
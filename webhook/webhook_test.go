@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscription_Validate(t *testing.T) {
+	t.Parallel()
+
+	valid := Subscription{
+		ID:         uuid.New(),
+		URL:        "https://example.com/hook",
+		EventTypes: []string{company.EventCreated},
+		Secret:     "0123456789abcdef",
+	}
+	require.NoError(t, valid.Validate())
+
+	invalid := Subscription{
+		URL:        "ftp://example.com",
+		EventTypes: []string{"company.merged.v1"},
+		Secret:     "short",
+	}
+	err := invalid.Validate()
+	var verr *company.ValidationError
+	require.ErrorAs(t, err, &verr)
+	var fields []string
+	for _, v := range verr.Violations {
+		fields = append(fields, v.Field)
+	}
+	assert.Equal(t, []string{"id", "url", "secret", "event_types"}, fields)
+}
+
+func TestSubscription_Accepts(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, Subscription{}.Accepts(company.EventDeleted))
+	s := Subscription{EventTypes: []string{company.EventCreated}}
+	assert.True(t, s.Accepts(company.EventCreated))
+	assert.False(t, s.Accepts(company.EventDeleted))
+}
+
+func TestPatch_Apply(t *testing.T) {
+	t.Parallel()
+
+	disabledAt := time.Now()
+	s := Subscription{URL: "https://a.example", Active: false, Failures: 5, DisabledAt: &disabledAt}
+	url, active := "https://b.example", true
+	s = Patch{URL: &url, Active: &active}.Apply(s)
+	assert.Equal(t, url, s.URL)
+	assert.True(t, s.Active)
+	assert.Zero(t, s.Failures)
+	assert.Nil(t, s.DisabledAt)
+}
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1667260800, 0)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1667260800.{}"))
+	assert.Equal(t, "t=1667260800,v1="+hex.EncodeToString(mac.Sum(nil)), Sign("secret", ts, []byte("{}")))
+}
@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	platform "github.com/a-romancev/crud_task/internal/platform/mongo"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	workerBatch    = 100
+	workerInterval = time.Second
+	// claimMargin is added to the request timeout to get the lease of a claimed delivery.
+	claimMargin = 30 * time.Second
+	// responseLimit bounds the part of the response body which is read, so that connections are reused.
+	responseLimit = 64 << 10
+)
+
+type WorkerConf struct {
+	// MaxAttempts of a delivery before it is failed.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// DisableAfter consecutive failed attempts the subscription is disabled, zero never disables.
+	DisableAfter int           `mapstructure:"disable_after"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	// Backoff is the delay after the first failed attempt, it doubles after every next one up to MaxBackoff.
+	Backoff    time.Duration `mapstructure:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// AllowPrivate lets deliveries reach private, loopback and link-local addresses, it is meant for local development.
+	AllowPrivate bool `mapstructure:"allow_private"`
+}
+
+// ErrForbiddenTarget is returned for deliveries to addresses of the internal network, so that webhooks
+// cannot be used to reach services which are not exposed. The address is checked when connecting,
+// after the host name is resolved and on every redirect.
+var ErrForbiddenTarget = errors.New("forbidden webhook target")
+
+func (c WorkerConf) Validate() error {
+	switch {
+	case c.MaxAttempts < 1:
+		return errors.New("webhook max_attempts must be positive")
+	case c.DisableAfter < 0:
+		return errors.New("webhook disable_after must not be negative")
+	case c.Timeout <= 0:
+		return errors.New("webhook timeout must be positive")
+	case c.Backoff <= 0:
+		return errors.New("webhook backoff must be positive")
+	case c.MaxBackoff < c.Backoff:
+		return errors.New("webhook max_backoff must not be less than backoff")
+	}
+	return nil
+}
+
+// store is the part of Mongo used by Worker.
+type store interface {
+	FetchOne(ctx context.Context, id uuid.UUID) (Subscription, error)
+	ClaimAllTenants(ctx context.Context, t time.Time, lease time.Duration) (Delivery, error)
+	Record(ctx context.Context, id uuid.UUID, attempt *Attempt, status string, next time.Time) error
+	Succeeded(ctx context.Context, id uuid.UUID) error
+	Failed(ctx context.Context, id uuid.UUID, disableAfter int) (bool, error)
+}
+
+// Worker sends due deliveries. Failed attempts are retried with exponential backoff until
+// the delivery runs out of attempts or its subscription is disabled. Every delivery is claimed
+// before it is sent, so that any number of workers can run side by side.
+type Worker struct {
+	store  store
+	conf   WorkerConf
+	client *http.Client
+}
+
+func NewWorker(store *Mongo, conf WorkerConf) *Worker {
+	return newWorker(store, conf)
+}
+
+func newWorker(store store, conf WorkerConf) *Worker {
+	return &Worker{
+		store:  store,
+		conf:   conf,
+		client: newClient(conf),
+	}
+}
+
+// newClient returns the client of deliveries, it connects to public addresses only unless private ones are allowed.
+// Proxies are not used, as the address of the proxy would be checked instead of the target.
+func newClient(conf WorkerConf) *http.Client {
+	dialer := &net.Dialer{Timeout: conf.Timeout}
+	if !conf.AllowPrivate {
+		dialer.Control = publicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: conf.Timeout, Transport: transport}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, see RFC 6598.
+var sharedAddressSpace = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicOnly refuses connections to loopback, private, link-local, multicast and unspecified addresses.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w %s", ErrForbiddenTarget, host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w %s", ErrForbiddenTarget, ip)
+	}
+	return nil
+}
+
+// Run sends deliveries until the context is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(workerInterval)
+	defer ticker.Stop()
+	for {
+		err := w.deliverDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to deliver webhooks.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue sends up to a batch of due deliveries claimed one by one.
+func (w *Worker) deliverDue(ctx context.Context) error {
+	for i := 0; i < workerBatch; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d, err := w.store.ClaimAllTenants(ctx, platform.Now(), w.conf.Timeout+claimMargin)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = w.deliver(ctx, d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends the delivery, the storage is scoped by the tenant of its event.
+func (w *Worker) deliver(ctx context.Context, d Delivery) error {
+	ctx = company.WithTenant(ctx, d.Event.TenantID)
+	s, err := w.store.FetchOne(ctx, d.SubscriptionID)
+	if errors.Is(err, ErrNotFound) || (err == nil && !s.Active) {
+		return w.store.Record(ctx, d.ID, nil, StatusCancelled, d.NextAttemptAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	a := w.send(ctx, s, d)
+	if a.OK() {
+		err := w.store.Record(ctx, d.ID, &a, StatusDelivered, d.NextAttemptAt)
+		if err != nil {
+			return err
+		}
+		if s.Failures > 0 {
+			return w.store.Succeeded(ctx, s.ID)
+		}
+		return nil
+	}
+
+	attempts := len(d.Attempts) + 1
+	status, next := StatusPending, a.Time.Add(w.backoff(attempts))
+	if attempts >= w.conf.MaxAttempts {
+		status, next = StatusFailed, a.Time
+	}
+	log.Ctx(ctx).Warn().
+		Str("delivery", d.ID.String()).
+		Str("subscription", s.ID.String()).
+		Int("attempts", attempts).
+		Str("error", a.Error).
+		Msg("Failed to deliver webhook.")
+	err = w.store.Record(ctx, d.ID, &a, status, next)
+	if err != nil {
+		return err
+	}
+	disabled, err := w.store.Failed(ctx, s.ID, w.conf.DisableAfter)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		log.Ctx(ctx).Warn().Str("subscription", s.ID.String()).Msg("Disabled failing webhook.")
+	}
+	return nil
+}
+
+// send posts the event to the subscription, any response but 2xx is a failure.
+func (w *Worker) send(ctx context.Context, s Subscription, d Delivery) (a Attempt) {
+	a.Time = platform.Now()
+	defer func() { a.Duration = time.Since(a.Time) }()
+
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(HeaderDelivery, d.ID.String())
+	req.Header.Set(HeaderSignature, Sign(s.Secret, a.Time, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, responseLimit))
+
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return a
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.conf.Backoff
+	for i := 1; i < attempts && d < w.conf.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.conf.MaxBackoff {
+		return w.conf.MaxBackoff
+	}
+	return d
+}
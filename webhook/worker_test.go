@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-romancev/crud_task/company"
+	"github.com/a-romancev/crud_task/internal/event"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	attempt *Attempt
+	status  string
+	next    time.Time
+}
+
+type fakeStore struct {
+	mu         sync.Mutex
+	subs       map[uuid.UUID]Subscription
+	deliveries []Delivery
+	records    map[uuid.UUID][]record
+}
+
+func newFakeStore(subs ...Subscription) *fakeStore {
+	s := &fakeStore{subs: make(map[uuid.UUID]Subscription), records: make(map[uuid.UUID][]record)}
+	for _, sub := range subs {
+		s.subs[sub.ID] = sub
+	}
+	return s
+}
+
+func (s *fakeStore) FetchOne(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	tenant, err := company.TenantFrom(ctx)
+	if err != nil {
+		return Subscription{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	if !ok || sub.TenantID != tenant {
+		return Subscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *fakeStore) ClaimAllTenants(_ context.Context, t time.Time, lease time.Duration) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, d := range s.deliveries {
+		if d.Status != StatusPending || d.NextAttemptAt.After(t) || (d.LockedUntil != nil && d.LockedUntil.After(t)) {
+			continue
+		}
+		until := t.Add(lease)
+		s.deliveries[i].LockedUntil = &until
+		return s.deliveries[i], nil
+	}
+	return Delivery{}, ErrNotFound
+}
+
+func (s *fakeStore) Record(ctx context.Context, id uuid.UUID, attempt *Attempt, status string, next time.Time) error {
+	_, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[id] = append(s.records[id], record{attempt: attempt, status: status, next: next})
+	for i, d := range s.deliveries {
+		if d.ID == id {
+			s.deliveries[i].Status, s.deliveries[i].NextAttemptAt, s.deliveries[i].LockedUntil = status, next, nil
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) Succeeded(ctx context.Context, id uuid.UUID) error {
+	_, err := company.TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.subs[id]
+	sub.Failures = 0
+	s.subs[id] = sub
+	return nil
+}
+
+func (s *fakeStore) Failed(ctx context.Context, id uuid.UUID, disableAfter int) (bool, error) {
+	_, err := company.TenantFrom(ctx)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.subs[id]
+	sub.Failures++
+	disabled := sub.Active && disableAfter > 0 && sub.Failures >= disableAfter
+	if disabled {
+		sub.Active = false
+	}
+	s.subs[id] = sub
+	return disabled, nil
+}
+
+var testConf = WorkerConf{
+	MaxAttempts:  3,
+	DisableAfter: 2,
+	Timeout:      time.Second,
+	Backoff:      10 * time.Second,
+	MaxBackoff:   time.Minute,
+	AllowPrivate: true,
+}
+
+func testDelivery(t *testing.T, sub Subscription, attempts int) Delivery {
+	t.Helper()
+	e, err := event.New(uuid.NewString(), "/v1/companies", "company.created.v1", uuid.NewString(), time.Now(), map[string]string{"name": "test"})
+	require.NoError(t, err)
+	e.TenantID = sub.TenantID
+	return Delivery{
+		ID:             uuid.New(),
+		SubscriptionID: sub.ID,
+		Event:          e,
+		Status:         StatusPending,
+		Attempts:       make([]Attempt, attempts),
+	}
+}
+
+func TestWorker_Deliver(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Signed delivery", func(t *testing.T) {
+		t.Parallel()
+
+		var got *http.Request
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		sub := Subscription{ID: uuid.New(), TenantID: "test", URL: srv.URL, Secret: "0123456789abcdef", Active: true, Failures: 1}
+		store := newFakeStore(sub)
+		d := testDelivery(t, sub, 0)
+		require.NoError(t, newWorker(store, testConf).deliver(context.Background(), d))
+
+		require.NotNil(t, got)
+		assert.Equal(t, http.MethodPost, got.Method)
+		assert.Equal(t, "application/cloudevents+json", got.Header.Get("Content-Type"))
+		assert.Equal(t, d.ID.String(), got.Header.Get(HeaderDelivery))
+		var e event.Event
+		require.NoError(t, json.Unmarshal(body, &e))
+		assert.Equal(t, d.Event.ID, e.ID)
+
+		records := store.records[d.ID]
+		require.Len(t, records, 1)
+		assert.Equal(t, StatusDelivered, records[0].status)
+		assert.Equal(t, http.StatusNoContent, records[0].attempt.StatusCode)
+		assert.True(t, records[0].attempt.OK())
+		assert.Equal(t, Sign(sub.Secret, records[0].attempt.Time, body), got.Header.Get(HeaderSignature))
+		assert.Zero(t, store.subs[sub.ID].Failures)
+	})
+
+	t.Run("Failed attempts back off and disable the subscription", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		sub := Subscription{ID: uuid.New(), TenantID: "test", URL: srv.URL, Secret: "0123456789abcdef", Active: true}
+		store := newFakeStore(sub)
+		w := newWorker(store, testConf)
+
+		first := testDelivery(t, sub, 0)
+		require.NoError(t, w.deliver(context.Background(), first))
+		r := store.records[first.ID][0]
+		assert.Equal(t, StatusPending, r.status)
+		assert.Equal(t, http.StatusInternalServerError, r.attempt.StatusCode)
+		assert.Equal(t, "unexpected status 500", r.attempt.Error)
+		assert.Equal(t, r.attempt.Time.Add(10*time.Second), r.next)
+		assert.True(t, store.subs[sub.ID].Active)
+
+		last := testDelivery(t, sub, 2)
+		require.NoError(t, w.deliver(context.Background(), last))
+		assert.Equal(t, StatusFailed, store.records[last.ID][0].status)
+		assert.False(t, store.subs[sub.ID].Active)
+
+		cancelled := testDelivery(t, sub, 0)
+		require.NoError(t, w.deliver(context.Background(), cancelled))
+		r = store.records[cancelled.ID][0]
+		assert.Equal(t, StatusCancelled, r.status)
+		assert.Nil(t, r.attempt)
+	})
+}
+
+func TestWorker_ForbiddenTarget(t *testing.T) {
+	t.Parallel()
+
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	conf := testConf
+	conf.AllowPrivate = false
+	sub := Subscription{ID: uuid.New(), TenantID: "test", URL: srv.URL, Secret: "0123456789abcdef", Active: true}
+	store := newFakeStore(sub)
+	d := testDelivery(t, sub, 0)
+	require.NoError(t, newWorker(store, conf).deliver(context.Background(), d))
+
+	assert.False(t, called)
+	r := store.records[d.ID][0]
+	assert.Equal(t, StatusPending, r.status)
+	assert.Contains(t, r.attempt.Error, ErrForbiddenTarget.Error())
+
+	for _, address := range []string{"127.0.0.1:80", "[::1]:80", "10.1.2.3:80", "192.168.0.1:443", "169.254.169.254:80", "[fe80::1]:80", "0.0.0.0:80", "100.64.0.1:80"} {
+		assert.ErrorIs(t, publicOnly("tcp", address, nil), ErrForbiddenTarget, address)
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		assert.NoError(t, publicOnly("tcp", address, nil), address)
+	}
+}
+
+func TestWorker_DeliverDue(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posted[r.Header.Get(HeaderDelivery)]++
+		mu.Unlock()
+		// Slow responses keep claims taken while the other worker looks for due deliveries.
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sub := Subscription{ID: uuid.New(), TenantID: "test", URL: srv.URL, Secret: "0123456789abcdef", Active: true}
+	store := newFakeStore(sub)
+	for i := 0; i < 50; i++ {
+		store.deliveries = append(store.deliveries, testDelivery(t, sub, 0))
+	}
+
+	// Two workers send the same due deliveries concurrently, each is claimed and posted by one of them.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, newWorker(store, testConf).deliverDue(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, posted, len(store.deliveries))
+	for _, d := range store.deliveries {
+		assert.Equal(t, 1, posted[d.ID.String()], d.ID)
+		assert.Equal(t, StatusDelivered, d.Status)
+	}
+}
+
+func TestWorker_Backoff(t *testing.T) {
+	t.Parallel()
+
+	w := newWorker(newFakeStore(), testConf)
+	assert.Equal(t, 10*time.Second, w.backoff(1))
+	assert.Equal(t, 20*time.Second, w.backoff(2))
+	assert.Equal(t, 40*time.Second, w.backoff(3))
+	assert.Equal(t, time.Minute, w.backoff(4))
+	assert.Equal(t, time.Minute, w.backoff(10))
+}